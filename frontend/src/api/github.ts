@@ -13,6 +13,7 @@ import {Action,
   ListRepoWorkflowRequest,
   ListServicesRequest,
   ListWorkflowsEntryRequest,
+  Pagination,
   Repository,
   RepoWorkflow,
   ServiceEntry,
@@ -21,6 +22,15 @@ import {Action,
   WorkflowEntry,} from "../models/github";
 
 const baseURL = "http://localhost:8080";
+const provider = process.env.REACT_APP_SCM_PROVIDER || "github";
+
+// With GitHub OAuth enabled the backend answers 401 until the user logs in.
+axios.interceptors.response.use(undefined, (error) => {
+  if (error.response && error.response.status === 401) {
+    window.location.href = baseURL + "/api/auth/login";
+  }
+  return Promise.reject(error);
+});
 
 export function ListWorkflows(
   req: ListWorkflowsEntryRequest,
@@ -31,7 +41,7 @@ export function ListWorkflows(
   axios.defaults.baseURL = baseURL;
 
   axios
-    .get(baseURL + "/api/github/repository/workflows", {
+    .get(baseURL + "/api/" + provider + "/repository/workflows", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -54,7 +64,7 @@ export function GetWorkflow(
   axios.defaults.baseURL = baseURL;
 
   axios
-    .get(baseURL + "/api/github/repository/workflow", {
+    .get(baseURL + "/api/" + provider + "/repository/workflow", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -79,7 +89,7 @@ export function GetAction(
   axios.defaults.baseURL = baseURL;
 
   axios
-    .get(baseURL + "/api/github/repository/action", {
+    .get(baseURL + "/api/" + provider + "/repository/action", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -94,7 +104,7 @@ export function GetAction(
     .catch(() => {
       console.log("Got Error. Retrying with main");
       axios
-        .get(baseURL + "/api/github/repository/action", {
+        .get(baseURL + "/api/" + provider + "/repository/action", {
           params: {
             repoOwner: req.repoOwner,
             repoName: req.repoName,
@@ -111,7 +121,7 @@ export function GetAction(
 
 export function ListRepositories(
   req: ListRepositoriesRequest,
-  handleResponse: (response: Repository[]) => void
+  handleResponse: (response: Repository[], pagination: Pagination) => void
 ) {
   console.log("ListRepositories");
 
@@ -122,15 +132,18 @@ export function ListRepositories(
 
   axios.defaults.baseURL = baseURL;
   axios
-    .get(baseURL + "/api/github/repositories", {
+    .get(baseURL + "/api/" + provider + "/repositories", {
       params: {
         repoOwner: req.repoOwner,
+        q: req.q,
+        page: req.page,
+        perPage: req.perPage,
       },
     })
     .then((resp) => {
       console.log("Got Response: ");
       console.log(resp);
-      handleResponse(resp.data.data);
+      handleResponse(resp.data.data, resp.data.pagination);
     });
 }
 
@@ -147,10 +160,13 @@ export function ListBranches(
 
   axios.defaults.baseURL = baseURL;
   axios
-    .get(baseURL + "/api/github/repository/branches", {
+    .get(baseURL + "/api/" + provider + "/repository/branches", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
+        q: req.q,
+        page: req.page,
+        perPage: req.perPage,
       },
     })
     .then((resp) => {
@@ -174,7 +190,7 @@ export function ListCharts(
 
   axios.defaults.baseURL = baseURL;
   axios
-    .get(baseURL + "/api/github/repository/charts", {
+    .get(baseURL + "/api/" + provider + "/repository/charts", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -202,7 +218,7 @@ export function ListServices(
 
   axios.defaults.baseURL = baseURL;
   axios
-    .get(baseURL + "/api/github/repository/services", {
+    .get(baseURL + "/api/" + provider + "/repository/services", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -231,7 +247,7 @@ export function ListRepoWorkflows(
 
   axios.defaults.baseURL = baseURL;
   axios
-    .get(baseURL + "/api/github/repository/workflows", {
+    .get(baseURL + "/api/" + provider + "/repository/workflows", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -259,7 +275,7 @@ export function ListRepoWorkflowsWithFiles(
 
   axios.defaults.baseURL = baseURL;
   axios
-    .get(baseURL + "/api/github/repository/workflowswithfiles", {
+    .get(baseURL + "/api/" + provider + "/repository/workflowswithfiles", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -287,7 +303,7 @@ export function ListDirectories(
 
   axios.defaults.baseURL = baseURL;
   axios
-    .get(baseURL + "/api/github/repository/chartdirectory", {
+    .get(baseURL + "/api/" + provider + "/repository/chartdirectory", {
       params: {
         repoOwner: req.repoOwner,
         repoName: req.repoName,
@@ -314,7 +330,7 @@ export function CreateIngressPR(
   }
 
   axios.defaults.baseURL = baseURL;
-  axios.post(baseURL + "/api/github/repository/pr", req).then((resp) => {
+  axios.post(baseURL + "/api/" + provider + "/repository/pr", req).then((resp) => {
     console.log("Got Response: ");
     console.log(resp);
     handleResponse(resp.data.pullRequestURL);
@@ -329,7 +345,7 @@ export function CreateActionPR(
   console.log(req);
 
   axios.defaults.baseURL = baseURL;
-  axios.post(baseURL + "/api/github/repository/actionpr", req).then((resp) => {
+  axios.post(baseURL + "/api/" + provider + "/repository/actionpr", req).then((resp) => {
     console.log("Got Response: ");
     console.log(resp);
     handleResponse(resp.data.pullRequestURL);
@@ -344,7 +360,7 @@ export function CreateWorkflowPR(
   console.log(req);
   axios.defaults.baseURL = baseURL;
   axios
-    .post(baseURL + "/api/github/repository/pr/workflow", req)
+    .post(baseURL + "/api/" + provider + "/repository/pr/workflow", req)
     .then((resp) => {
       console.log("Got Response: ");
       console.log(resp);
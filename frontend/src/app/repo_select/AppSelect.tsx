@@ -3,9 +3,11 @@ import { Button, Container, Divider, List, ListItem, ListItemButton, ListItemIco
 import * as React from 'react';
 import { useState } from 'react';
 import { ListBranches, ListCharts, ListRepositories } from '../../api/github';
-import { Branch, ChartEntry, Repository } from '../../models/github';
+import { Branch, ChartEntry, Pagination, Repository } from '../../models/github';
 import { AppState, BaseDisplayState } from '../../models/types';
 
+const repositoriesPerPage = 50;
+
 export default function AppSelect(
   props: {
     appState: AppState, 
@@ -13,6 +15,8 @@ export default function AppSelect(
   }) {
   const {appState, setAppState} = props;
   const [repositories, setRepositories] = useState<Repository[]>([]);
+  const [repositoryPagination, setRepositoryPagination] = useState<Pagination | undefined>();
+  const [repositoryFilter, setRepositoryFilter] = useState<string>("");
   const [selectedRepository, setSelectedRepository] = useState<Repository | undefined>();
   const [branches, setBranches] = useState<Branch[]>([]);
   const [selectedBranch, setSelectedBranch] = useState<Branch | undefined>();
@@ -22,9 +26,31 @@ export default function AppSelect(
  
   React.useEffect(() => {
     ListRepositories({
-      repoOwner: appState.ghUserName
-    }, setRepositories)
-  }, [appState]);
+      repoOwner: appState.ghUserName,
+      q: repositoryFilter,
+      page: 1,
+      perPage: repositoriesPerPage
+    }, (repos, pagination) => {
+      setRepositories(repos);
+      setRepositoryPagination(pagination);
+    })
+  }, [appState.ghUserName, repositoryFilter]);
+
+  const loadMoreRepositories = () => {
+    if (repositoryPagination == undefined) {
+      return;
+    }
+
+    ListRepositories({
+      repoOwner: appState.ghUserName,
+      q: repositoryFilter,
+      page: repositoryPagination.page + 1,
+      perPage: repositoriesPerPage
+    }, (repos, pagination) => {
+      setRepositories([...repositories, ...repos]);
+      setRepositoryPagination(pagination);
+    })
+  }
 
   React.useEffect(() => {
     if (selectedRepository != undefined) {
@@ -123,6 +149,9 @@ export default function AppSelect(
             <Typography variant="h6" align="center" color="text.secondary" paragraph>
               Repository:
             </Typography>
+            <TextField sx={{
+              margin: '4px'
+            }} label="Filter" variant="standard" value={repositoryFilter} onChange={(e) => setRepositoryFilter(e.target.value)} />
             <List
               sx={{
                 overflow: 'auto',
@@ -151,6 +180,9 @@ export default function AppSelect(
                 })
               }
             </List>
+            {repositoryPagination != undefined && repositoryPagination.hasMore &&
+              <Button onClick={() => loadMoreRepositories()}>Load More</Button>
+            }
           </div>
           <div style={{
             display: 'flex', flexDirection:'column', flex: 1, padding: '4px'
@@ -1,10 +1,16 @@
 export interface ListRepositoriesRequest {
   repoOwner: string;
+  q?: string;
+  page?: number;
+  perPage?: number;
 }
 
 export interface ListBranchesRequest {
   repoOwner: string;
   repoName: string;
+  q?: string;
+  page?: number;
+  perPage?: number;
 }
 
 export interface ListChartEntryRequest {
@@ -106,6 +112,13 @@ export interface Repository {
   description: string;
 }
 
+export interface Pagination {
+  page: number;
+  perPage: number;
+  totalCount?: number;
+  hasMore: boolean;
+}
+
 export interface Branch {
   name: string;
   sha: string;
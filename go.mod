@@ -3,10 +3,17 @@ module k8s-tooling-adapter
 go 1.16
 
 require (
+	github.com/go-git/go-git/v5 v5.4.2
 	github.com/google/go-github/v38 v38.1.0
 	github.com/gorilla/mux v1.8.0
-	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
-	gopkg.in/yaml.v2 v2.4.0 // indirect
-	k8s.io/api v0.22.1
-	k8s.io/client-go v0.22.1
+	github.com/xanzy/go-gitlab v0.50.3
+	golang.org/x/oauth2 v0.0.0-20210402161424-2e8d93401602
+	gopkg.in/yaml.v2 v2.4.0
+	helm.sh/helm/v3 v3.7.2
+	k8s.io/api v0.22.4
+	k8s.io/apimachinery v0.22.4
+	k8s.io/client-go v0.22.4
+	sigs.k8s.io/kustomize/api v0.8.11
+	sigs.k8s.io/kustomize/kyaml v0.11.0
+	sigs.k8s.io/yaml v1.2.0
 )
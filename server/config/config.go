@@ -0,0 +1,465 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	configFileEnv     = "K8S_TOOLS_CONFIG"
+	defaultConfigFile = "config.json"
+	envPrefix         = "K8S_TOOLS_"
+)
+
+// Config is the runtime configuration of the server. Values are layered, each
+// overriding the previous one: defaults, the JSON config file, K8S_TOOLS_*
+// environment variables and finally command line flags.
+type Config struct {
+	ListenAddress string `json:"listenAddress"`
+	TLSCertFile   string `json:"tlsCertFile"`
+	TLSKeyFile    string `json:"tlsKeyFile"`
+
+	Provider          string             `json:"provider"`
+	FakeFixtureDir    string             `json:"fakeFixtureDir"`
+	GitHubAccessToken string             `json:"ghAccessToken"`
+	GitHubBaseURL     string             `json:"ghBaseURL"`
+	GitHubUploadURL   string             `json:"ghUploadURL"`
+	GitHubCloneHost   string             `json:"ghCloneHost"`
+	GitHubCABundle    string             `json:"ghCABundle"`
+	GitHubOAuth       GitHubOAuthConfig  `json:"ghOAuth"`
+	GitHubApp         GitHubAppConfig    `json:"ghApp"`
+	GitHubHosts       []GitHubHostConfig `json:"ghHosts"`
+	GitLabAccessToken string             `json:"gitlabAccessToken"`
+	GitLabBaseURL     string             `json:"gitlabBaseURL"`
+	LocalRepoRoot     string             `json:"localRepositoryRoot"`
+
+	ListPageLimit           int    `json:"listPageLimit"`
+	ObjectCacheMaxBytes     int64  `json:"objectCacheMaxBytes"`
+	ObjectCacheDir          string `json:"objectCacheDir"`
+	ObjectCacheDiskMaxBytes int64  `json:"objectCacheDiskMaxBytes"`
+
+	CloneWorkDir         string   `json:"cloneWorkDir"`
+	CloneWorkDirMaxBytes int64    `json:"cloneWorkDirMaxBytes"`
+	CheckoutMaxBytes     int64    `json:"checkoutMaxBytes"`
+	SparseCheckouts      bool     `json:"sparseCheckouts"`
+	RepoCacheTTL         Duration `json:"repoCacheTTL"`
+	SessionIdleTimeout   Duration `json:"sessionIdleTimeout"`
+	HelmRenderTimeout    Duration `json:"helmRenderTimeout"`
+	HelmRenderMaxBytes   int64    `json:"helmRenderMaxBytes"`
+
+	CommitAuthor CommitAuthorConfig `json:"commitAuthor"`
+}
+
+type GitHubOAuthConfig struct {
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+	RedirectURL  string `json:"redirectURL"`
+}
+
+type GitHubAppConfig struct {
+	AppID          int64  `json:"appID"`
+	PrivateKeyPath string `json:"privateKeyPath"`
+}
+
+// GitHubHostConfig is an additional GitHub instance, served on the
+// /api/{name}/... routes next to the default github provider.
+type GitHubHostConfig struct {
+	Name        string `json:"name"`
+	AccessToken string `json:"accessToken"`
+	BaseURL     string `json:"baseURL"`
+	UploadURL   string `json:"uploadURL"`
+	CloneHost   string `json:"cloneHost"`
+	CABundle    string `json:"caBundle"`
+}
+
+type CommitAuthorConfig struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+// Duration is a time.Duration read from JSON as a string such as "10m".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10m\": %s", err.Error())
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
+func defaults() *Config {
+	return &Config{
+		ListenAddress:           "0.0.0.0:8080",
+		Provider:                "github",
+		ListPageLimit:           50,
+		ObjectCacheMaxBytes:     64 << 20,
+		ObjectCacheDiskMaxBytes: 1 << 30,
+		CloneWorkDirMaxBytes:    8 << 30,
+		CheckoutMaxBytes:        1 << 30,
+		SparseCheckouts:         true,
+		RepoCacheTTL:            Duration{10 * time.Minute},
+		SessionIdleTimeout:      Duration{12 * time.Hour},
+		HelmRenderTimeout:       Duration{30 * time.Second},
+		HelmRenderMaxBytes:      64 << 20,
+		CommitAuthor: CommitAuthorConfig{
+			Name:    "bfoley13",
+			Email:   "brandonfoley13@gmail.com",
+			Message: "k8s-ingress-extension",
+		},
+	}
+}
+
+// reservedProviderNames are the provider route names that additional GitHub
+// hosts cannot take.
+var reservedProviderNames = map[string]bool{
+	"auth":   true,
+	"cache":  true,
+	"github": true,
+	"gitlab": true,
+	"local":  true,
+	"fake":   true,
+}
+
+// option is a setting that can be overridden from the environment and from
+// the command line.
+type option struct {
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+	// isBool lets the flag be given without a value, meaning true.
+	isBool bool
+}
+
+// flagValue records the value given for an option on the command line, which
+// is applied once the config file and the environment have been read.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	v.value = value
+	return nil
+}
+
+// IsBoolFlag is how the flag package tells `-name` from `-name value`.
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+func (o option) env() string {
+	return envPrefix + strings.ToUpper(strings.Replace(o.flag, "-", "_", -1))
+}
+
+var options = []option{
+	stringOption("listen-address", "address the HTTP server listens on", func(c *Config) *string { return &c.ListenAddress }),
+	stringOption("tls-cert-file", "TLS certificate file, enables HTTPS together with tls-key-file", func(c *Config) *string { return &c.TLSCertFile }),
+	stringOption("tls-key-file", "TLS private key file", func(c *Config) *string { return &c.TLSKeyFile }),
+	stringOption("provider", "source control provider served on the github routes: github or fake", func(c *Config) *string { return &c.Provider }),
+	stringOption("fake-fixture-dir", "directory of <owner>/<repo> fixtures the fake provider is seeded with", func(c *Config) *string { return &c.FakeFixtureDir }),
+	stringOption("github-token", "GitHub personal access token", func(c *Config) *string { return &c.GitHubAccessToken }),
+	stringOption("github-base-url", "GitHub API base URL, e.g. https://ghe.example.com/api/v3/ for GitHub Enterprise Server", func(c *Config) *string { return &c.GitHubBaseURL }),
+	stringOption("github-upload-url", "GitHub upload API URL, defaults to github-base-url", func(c *Config) *string { return &c.GitHubUploadURL }),
+	stringOption("github-clone-host", "host repositories are cloned from, defaults to the host of github-base-url", func(c *Config) *string { return &c.GitHubCloneHost }),
+	stringOption("github-ca-bundle", "PEM file of extra certificate authorities trusted for the GitHub host", func(c *Config) *string { return &c.GitHubCABundle }),
+	stringOption("github-oauth-client-id", "GitHub OAuth app client ID, enables per-user login", func(c *Config) *string { return &c.GitHubOAuth.ClientID }),
+	stringOption("github-oauth-client-secret", "GitHub OAuth app client secret", func(c *Config) *string { return &c.GitHubOAuth.ClientSecret }),
+	stringOption("github-oauth-redirect-url", "GitHub OAuth callback URL", func(c *Config) *string { return &c.GitHubOAuth.RedirectURL }),
+	int64Option("github-app-id", "GitHub App ID, enables GitHub App authentication", func(c *Config) *int64 { return &c.GitHubApp.AppID }),
+	stringOption("github-app-private-key-path", "GitHub App PEM private key file", func(c *Config) *string { return &c.GitHubApp.PrivateKeyPath }),
+	stringOption("gitlab-token", "GitLab access token, enables the gitlab provider", func(c *Config) *string { return &c.GitLabAccessToken }),
+	stringOption("gitlab-base-url", "GitLab base URL, enables the gitlab provider", func(c *Config) *string { return &c.GitLabBaseURL }),
+	stringOption("local-repository-root", "directory of local git repositories, enables the local provider", func(c *Config) *string { return &c.LocalRepoRoot }),
+	intOption("list-page-limit", "maximum number of pages followed when listing repositories or branches, 0 for no limit", func(c *Config) *int { return &c.ListPageLimit }),
+	int64Option("object-cache-max-bytes", "memory used to cache git trees and blobs, 0 disables the cache", func(c *Config) *int64 { return &c.ObjectCacheMaxBytes }),
+	stringOption("object-cache-dir", "directory git trees and blobs are also cached in, survives restarts", func(c *Config) *string { return &c.ObjectCacheDir }),
+	int64Option("object-cache-disk-max-bytes", "disk used by the object cache directory, least recently used files are removed to stay under it, 0 for no limit", func(c *Config) *int64 { return &c.ObjectCacheDiskMaxBytes }),
+	stringOption("clone-work-dir", "directory cached clones are written to, defaults to a directory in the system temp directory", func(c *Config) *string { return &c.CloneWorkDir }),
+	int64Option("clone-work-dir-max-bytes", "disk used by all cached clones, idle clones are removed to stay under it, 0 for no limit", func(c *Config) *int64 { return &c.CloneWorkDirMaxBytes }),
+	int64Option("checkout-max-bytes", "disk a single cached clone may use, 0 for no limit", func(c *Config) *int64 { return &c.CheckoutMaxBytes }),
+	boolOption("sparse-checkouts", "only check out the charts being rendered in cached clones", func(c *Config) *bool { return &c.SparseCheckouts }),
+	durationOption("repo-cache-ttl", "how long an unused clone is kept", func(c *Config) *Duration { return &c.RepoCacheTTL }),
+	durationOption("session-idle-timeout", "how long an unused login session is kept", func(c *Config) *Duration { return &c.SessionIdleTimeout }),
+	durationOption("helm-render-timeout", "how long rendering a chart, including building its dependencies, may take, 0 for no limit", func(c *Config) *Duration { return &c.HelmRenderTimeout }),
+	int64Option("helm-render-max-bytes", "size a chart with its dependencies and a rendered manifest may have, 0 for no limit", func(c *Config) *int64 { return &c.HelmRenderMaxBytes }),
+	stringOption("commit-author-name", "author name of generated commits", func(c *Config) *string { return &c.CommitAuthor.Name }),
+	stringOption("commit-author-email", "author email of generated commits", func(c *Config) *string { return &c.CommitAuthor.Email }),
+	stringOption("commit-message", "message of generated commits", func(c *Config) *string { return &c.CommitAuthor.Message }),
+}
+
+func stringOption(name, usage string, field func(c *Config) *string) option {
+	return option{flag: name, usage: usage, set: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func intOption(name, usage string, field func(c *Config) *int) option {
+	return option{flag: name, usage: usage, set: func(c *Config, value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func int64Option(name, usage string, field func(c *Config) *int64) option {
+	return option{flag: name, usage: usage, set: func(c *Config, value string) error {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func boolOption(name, usage string, field func(c *Config) *bool) option {
+	return option{flag: name, usage: usage, isBool: true, set: func(c *Config, value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func durationOption(name, usage string, field func(c *Config) *Duration) option {
+	return option{flag: name, usage: usage, set: func(c *Config, value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field(c).Duration = parsed
+		return nil
+	}}
+}
+
+// Load builds the configuration from the command line arguments (without the
+// program name), the environment and the config file, and validates it.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("k8s_tools", flag.ContinueOnError)
+	configFile := fs.String("config", "", fmt.Sprintf("path to the JSON config file (env %s, default %s if present)", configFileEnv, defaultConfigFile))
+	flagValues := make(map[string]*flagValue, len(options))
+	for _, opt := range options {
+		flagValues[opt.flag] = &flagValue{isBool: opt.isBool}
+		fs.Var(flagValues[opt.flag], opt.flag, fmt.Sprintf("%s (env %s)", opt.usage, opt.env()))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaults()
+	if err := cfg.loadFile(*configFile); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range options {
+		if value, ok := os.LookupEnv(opt.env()); ok {
+			if err := opt.set(cfg, value); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %s", opt.env(), err.Error())
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, opt := range options {
+			if opt.flag != f.Name || flagErr != nil {
+				continue
+			}
+			if err := opt.set(cfg, flagValues[opt.flag].value); err != nil {
+				flagErr = fmt.Errorf("invalid value for -%s: %s", opt.flag, err.Error())
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile reads the config file. An explicitly requested file must exist, the
+// default config.json is optional.
+func (c *Config) loadFile(path string) error {
+	explicit := true
+	if path == "" {
+		path = os.Getenv(configFileEnv)
+	}
+	if path == "" {
+		path = defaultConfigFile
+		explicit = false
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file %s: %s", path, err.Error())
+	}
+
+	if err := json.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %s", path, err.Error())
+	}
+
+	return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var problems []string
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		addProblem("listenAddress %q is not a host:port address", c.ListenAddress)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		addProblem("tlsCertFile and tlsKeyFile must be set together")
+	}
+	for _, file := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			addProblem("TLS file %s is not readable: %s", file, err.Error())
+		}
+	}
+
+	if c.Provider != "github" && c.Provider != "fake" {
+		addProblem("provider must be \"github\" or \"fake\", got %q", c.Provider)
+	}
+	if c.FakeFixtureDir != "" {
+		if c.Provider != "fake" {
+			addProblem("fakeFixtureDir is only used with provider \"fake\"")
+		}
+		if info, err := os.Stat(c.FakeFixtureDir); err != nil || !info.IsDir() {
+			addProblem("fakeFixtureDir %s is not a readable directory", c.FakeFixtureDir)
+		}
+	}
+
+	oauth := c.GitHubOAuth
+	if oauth.ClientID != "" && (oauth.ClientSecret == "" || oauth.RedirectURL == "") {
+		addProblem("ghOAuth.clientSecret and ghOAuth.redirectURL are required when ghOAuth.clientID is set")
+	}
+
+	if c.GitHubUploadURL != "" && c.GitHubBaseURL == "" {
+		addProblem("ghBaseURL is required when ghUploadURL is set")
+	}
+	if c.GitHubCABundle != "" {
+		if _, err := os.Stat(c.GitHubCABundle); err != nil {
+			addProblem("ghCABundle %s is not readable: %s", c.GitHubCABundle, err.Error())
+		}
+	}
+
+	hostNames := map[string]bool{}
+	for i, host := range c.GitHubHosts {
+		switch {
+		case host.Name == "":
+			addProblem("ghHosts[%d].name must not be empty", i)
+		case reservedProviderNames[host.Name]:
+			addProblem("ghHosts[%d].name %q is reserved", i, host.Name)
+		case hostNames[host.Name]:
+			addProblem("ghHosts[%d].name %q is used more than once", i, host.Name)
+		}
+		hostNames[host.Name] = true
+
+		if host.BaseURL == "" {
+			addProblem("ghHosts[%d].baseURL must not be empty", i)
+		}
+		if host.CABundle != "" {
+			if _, err := os.Stat(host.CABundle); err != nil {
+				addProblem("ghHosts[%d].caBundle %s is not readable: %s", i, host.CABundle, err.Error())
+			}
+		}
+	}
+
+	if c.GitHubApp.AppID != 0 && c.GitHubApp.PrivateKeyPath == "" {
+		addProblem("ghApp.privateKeyPath is required when ghApp.appID is set")
+	}
+	if c.GitHubApp.AppID == 0 && c.GitHubApp.PrivateKeyPath != "" {
+		addProblem("ghApp.appID is required when ghApp.privateKeyPath is set")
+	}
+
+	if c.ListPageLimit < 0 {
+		addProblem("listPageLimit must not be negative")
+	}
+	if c.ObjectCacheMaxBytes < 0 {
+		addProblem("objectCacheMaxBytes must not be negative")
+	}
+	if c.ObjectCacheDiskMaxBytes < 0 {
+		addProblem("objectCacheDiskMaxBytes must not be negative")
+	}
+
+	if c.CloneWorkDirMaxBytes < 0 {
+		addProblem("cloneWorkDirMaxBytes must not be negative")
+	}
+	if c.CheckoutMaxBytes < 0 {
+		addProblem("checkoutMaxBytes must not be negative")
+	}
+	if c.RepoCacheTTL.Duration <= 0 {
+		addProblem("repoCacheTTL must be positive")
+	}
+	if c.SessionIdleTimeout.Duration <= 0 {
+		addProblem("sessionIdleTimeout must be positive")
+	}
+	if c.HelmRenderTimeout.Duration < 0 {
+		addProblem("helmRenderTimeout must not be negative")
+	}
+	if c.HelmRenderMaxBytes < 0 {
+		addProblem("helmRenderMaxBytes must not be negative")
+	}
+
+	if c.CommitAuthor.Name == "" || c.CommitAuthor.Email == "" || c.CommitAuthor.Message == "" {
+		addProblem("commitAuthor.name, commitAuthor.email and commitAuthor.message must not be empty")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	return nil
+}
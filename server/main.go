@@ -3,42 +3,66 @@ package main
 import (
 	"context"
 	"embed"
-	"encoding/json"
 	"io/fs"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
+	"k8s-tooling-adapter/server/config"
 	"k8s-tooling-adapter/server/router"
 	"k8s-tooling-adapter/server/routes"
+	"k8s-tooling-adapter/server/types"
 )
 
-type AppConfig struct {
-	GitHubAccessToken string `json:"ghAccessToken"`
-}
-
 //go:embed build/*
 var embeddedFiles embed.FS
 
-//go:embed config.json
-var configBytes []byte
-
 func main() {
-	appConfig := loadConfigFile()
-	k8sService := routes.NewK8sService(appConfig.GitHubAccessToken)
+	types.ConfigureLogRedaction(os.Stderr)
+	appConfig, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	types.RedactSecret(appConfig.GitHubOAuth.ClientSecret)
+
+	types.ConfigureCommitAuthor(appConfig.CommitAuthor.Name, appConfig.CommitAuthor.Email, appConfig.CommitAuthor.Message)
+	types.ConfigureListPageLimit(appConfig.ListPageLimit)
+	if err := types.ConfigureObjectCache(appConfig.ObjectCacheMaxBytes, appConfig.ObjectCacheDir, appConfig.ObjectCacheDiskMaxBytes); err != nil {
+		log.Fatal(err)
+	}
+	lrs, err := types.NewLocalRepoService(appConfig.CloneWorkDir, appConfig.RepoCacheTTL.Duration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lrs.ConfigureCheckouts(appConfig.SparseCheckouts, appConfig.CheckoutMaxBytes, appConfig.CloneWorkDirMaxBytes)
+	lrs.ConfigureHelm(appConfig.HelmRenderTimeout.Duration, appConfig.HelmRenderMaxBytes)
+	githubHost := defaultGitHubHost(appConfig)
+	k8sService := routes.NewK8sService(newProviders(appConfig, githubHost, lrs), lrs)
+	if appConfig.GitHubOAuth.ClientID != "" {
+		log.Println("Enabling GitHub OAuth login")
+		k8sService.EnableGitHubOAuth(routes.NewGitHubOAuthConfig(appConfig.GitHubOAuth.ClientID, appConfig.GitHubOAuth.ClientSecret, appConfig.GitHubOAuth.RedirectURL, githubHost), githubHost, appConfig.SessionIdleTimeout.Duration)
+	}
 
 	r := router.NewRouter(k8sService, getFileSystem)
 
 	srv := &http.Server{
-		Addr:    "0.0.0.0:8080",
+		Addr:    appConfig.ListenAddress,
 		Handler: r,
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Println(err)
+		log.Println("Listening on: ", appConfig.ListenAddress)
+		var err error
+		if appConfig.TLSCertFile != "" {
+			err = srv.ListenAndServeTLS(appConfig.TLSCertFile, appConfig.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
 		}
 	}()
 
@@ -51,6 +75,9 @@ func main() {
 	defer cancel()
 
 	srv.Shutdown(ctx)
+	if fakeProvider, ok := k8sService.Providers["fake"].(*types.FakeProvider); ok {
+		fakeProvider.Close()
+	}
 
 	log.Println("shutting down")
 	os.Exit(0)
@@ -66,8 +93,96 @@ func getFileSystem() http.FileSystem {
 	return http.FS(fsys)
 }
 
-func loadConfigFile() AppConfig {
-	appConfig := AppConfig{}
-	json.Unmarshal(configBytes, &appConfig)
-	return appConfig
+func defaultGitHubHost(appConfig *config.Config) types.GitHubHost {
+	host, err := types.NewGitHubHost(types.GitHubHost{
+		BaseURL:   appConfig.GitHubBaseURL,
+		UploadURL: appConfig.GitHubUploadURL,
+		CloneHost: appConfig.GitHubCloneHost,
+		CABundle:  appConfig.GitHubCABundle,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return host
+}
+
+func newProviders(appConfig *config.Config, githubHost types.GitHubHost, lrs *types.LocalRepoService) map[string]types.SourceControlProvider {
+	githubClient, err := types.NewGithubClient(context.Background(), appConfig.GitHubAccessToken, githubHost)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := lrs.AddGitCABundle(githubHost.GetCloneHost(), githubHost.CABundle); err != nil {
+		log.Fatal(err)
+	}
+
+	providers := map[string]types.SourceControlProvider{
+		"github": githubClient,
+	}
+
+	for _, hostConfig := range appConfig.GitHubHosts {
+		host, err := types.NewGitHubHost(types.GitHubHost{
+			BaseURL:   hostConfig.BaseURL,
+			UploadURL: hostConfig.UploadURL,
+			CloneHost: hostConfig.CloneHost,
+			CABundle:  hostConfig.CABundle,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		hostClient, err := types.NewGithubClient(context.Background(), hostConfig.AccessToken, host)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := lrs.AddGitCABundle(host.GetCloneHost(), host.CABundle); err != nil {
+			log.Fatal(err)
+		}
+		providers[hostConfig.Name] = hostClient
+	}
+
+	if appConfig.GitHubApp.AppID != 0 {
+		privateKey, err := ioutil.ReadFile(appConfig.GitHubApp.PrivateKeyPath)
+		if err != nil {
+			log.Fatal("failed to read GitHub App private key: ", err)
+		}
+
+		appProvider, err := types.NewGithubAppProvider(appConfig.GitHubApp.AppID, privateKey, githubHost)
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers["github"] = appProvider
+	}
+
+	if appConfig.GitLabAccessToken != "" || appConfig.GitLabBaseURL != "" {
+		gitlabClient, err := types.NewGitlabClient(appConfig.GitLabAccessToken, appConfig.GitLabBaseURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers["gitlab"] = gitlabClient
+	}
+
+	if appConfig.LocalRepoRoot != "" {
+		localProvider, err := types.NewLocalGitProvider(appConfig.LocalRepoRoot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers["local"] = localProvider
+	}
+
+	// The fake provider also takes over the github routes so the bundled
+	// frontend can run offline without changes.
+	if appConfig.Provider == "fake" {
+		log.Println("Using in-memory fake source control provider")
+		fakeProvider := types.NewFakeProvider()
+		if appConfig.FakeFixtureDir != "" {
+			if err := fakeProvider.LoadFixtures(appConfig.FakeFixtureDir); err != nil {
+				log.Fatal(err)
+			}
+		}
+		providers["fake"] = fakeProvider
+		providers["github"] = fakeProvider
+	}
+
+	return providers
 }
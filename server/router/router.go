@@ -10,18 +10,27 @@ import (
 
 func NewRouter(apiServer *routes.K8sService, getFS func() http.FileSystem) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(apiServer.RateLimitHeaders)
 
-	router.HandleFunc("/api/github/repositories", corsHandler(apiServer.ListRepositories, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/branches", corsHandler(apiServer.ListRepoBranches, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/charts", corsHandler(apiServer.ListManifestOption, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/workflows", corsHandler(apiServer.ListRepoWorkflows, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/workflowswithfiles", corsHandler(apiServer.ListRepositoryWorkflows, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/services", corsHandler(apiServer.ListServices, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/chartdirectory", corsHandler(apiServer.GetChartDirectories, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/pr", corsHandler(apiServer.CreateIngressPullRequest, "POST")).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/github/repository/actionpr", corsHandler(apiServer.CreateActionPr, "POST")).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/github/repository/action", corsHandler(apiServer.GetRepoAction, "GET")).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/github/repository/workflow", corsHandler(apiServer.GetWorkflowFile, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/auth/login", apiServer.Login).Methods("GET")
+	router.HandleFunc("/api/auth/callback", apiServer.OAuthCallback).Methods("GET")
+	router.HandleFunc("/api/auth/logout", apiServer.Logout).Methods("POST")
+	router.HandleFunc("/api/auth/user", apiServer.CurrentUser).Methods("GET")
+
+	router.HandleFunc("/api/cache/stats", apiServer.GetCacheStats).Methods("GET")
+
+	router.HandleFunc("/api/{provider}/ratelimit", corsHandler(apiServer.GetRateLimit, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repositories", corsHandler(apiServer.ListRepositories, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/branches", corsHandler(apiServer.ListRepoBranches, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/charts", corsHandler(apiServer.ListManifestOption, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/workflows", corsHandler(apiServer.ListRepoWorkflows, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/workflowswithfiles", corsHandler(apiServer.ListRepositoryWorkflows, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/services", corsHandler(apiServer.ListServices, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/chartdirectory", corsHandler(apiServer.GetChartDirectories, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/pr", corsHandler(apiServer.CreateIngressPullRequest, "POST")).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/actionpr", corsHandler(apiServer.CreateActionPr, "POST")).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/action", corsHandler(apiServer.GetRepoAction, "GET")).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/{provider}/repository/workflow", corsHandler(apiServer.GetWorkflowFile, "GET")).Methods("GET", "OPTIONS")
 
 	//This handles serving the react bundle
 	router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(getFS())))
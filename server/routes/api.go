@@ -1,33 +1,94 @@
 package routes
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"k8s-tooling-adapter/server/types"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
 )
 
 type K8sService struct {
-	GHClient         types.GitHubClient
-	LocalRepoService types.LocalRepoService
-	accessToken      string
+	Providers        map[string]types.SourceControlProvider
+	LocalRepoService *types.LocalRepoService
+	OAuthConfig      *oauth2.Config
+	OAuthHost        types.GitHubHost
+	Sessions         *types.SessionStore
 }
 
-func NewK8sService(accessToken string) *K8sService {
-	client := types.NewGithubClient(context.Background(), accessToken)
-	lrs := types.NewLocalRepoService()
+// NewK8sService takes the source control providers keyed by the name used in
+// the `/api/{provider}/...` routes, e.g. "github" or "gitlab".
+func NewK8sService(providers map[string]types.SourceControlProvider, lrs *types.LocalRepoService) *K8sService {
 	return &K8sService{
-		GHClient:         client,
+		Providers:        providers,
 		LocalRepoService: lrs,
-		accessToken:      accessToken,
 	}
 }
 
+func (api *K8sService) getProvider(r *http.Request) (types.SourceControlProvider, error) {
+	name := mux.Vars(r)["provider"]
+	if name == "github" && api.OAuthConfig != nil {
+		provider, err := api.getUserGitHubClient(r)
+		if err == nil {
+			recordServedProvider(r, provider)
+		}
+		return provider, err
+	}
+
+	provider, ok := api.Providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported source control provider: %s", name)
+	}
+
+	recordServedProvider(r, provider)
+	return provider, nil
+}
+
+// providerErrorStatus is the status code to answer with when getProvider fails.
+func providerErrorStatus(err error) int {
+	if err == errUnauthorized {
+		return http.StatusUnauthorized
+	}
+
+	return http.StatusBadRequest
+}
+
+// WriteHTTPErrorResponse answers with code and the error message. Errors caused
+// by a GitHub rate limit are answered with 429 and a Retry-After header
+// instead, so clients can tell them apart from failures. Rejected repository
+// paths are answered with 400, and charts and kustomizations that fail to
+// render with 422, or 504 on a timeout, along with the code of the error.
 func (api *K8sService) WriteHTTPErrorResponse(w http.ResponseWriter, code int, errResp error) {
 	log.Println("[WriteHTTPErrorResponse] Error: ", errResp.Error())
+	if retryAfter, limited := types.IsRateLimitError(errResp); limited {
+		code = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	apiError := &types.APIError{Error: errResp.Error()}
+	var pathErr *types.PathError
+	var helmErr *types.HelmError
+	var kustomizeErr *types.KustomizeError
+	if errors.As(errResp, &pathErr) {
+		code = http.StatusBadRequest
+		apiError.Code = pathErr.Code
+	} else if errors.As(errResp, &helmErr) {
+		code = http.StatusUnprocessableEntity
+		if helmErr.Code == types.ErrCodeChartRenderTimeout {
+			code = http.StatusGatewayTimeout
+		}
+		apiError.Code = helmErr.Code
+	} else if errors.As(errResp, &kustomizeErr) {
+		code = http.StatusUnprocessableEntity
+		apiError.Code = types.ErrCodeKustomizeFailed
+	}
 	w.WriteHeader(code)
-	if err := json.NewEncoder(w).Encode(&types.APIError{Error: errResp.Error()}); err != nil {
+	if err := json.NewEncoder(w).Encode(apiError); err != nil {
 		log.Println("[WriteHTTPErrorResponse] failed to write http response: ", err.Error())
 	}
 }
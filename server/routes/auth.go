@@ -0,0 +1,208 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"k8s-tooling-adapter/server/types"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionCookieName    = "k8s_tools_session"
+	oauthStateCookieName = "k8s_tools_oauth_state"
+)
+
+var errUnauthorized = errors.New("not logged in")
+
+// NewGitHubOAuthConfig builds the OAuth web flow configuration for a GitHub
+// OAuth app registered on host. The scopes cover reading repositories,
+// including organization ones, and pushing workflow files.
+func NewGitHubOAuthConfig(clientID, clientSecret, redirectURL string, host types.GitHubHost) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  host.WebURL() + "/login/oauth/authorize",
+			TokenURL: host.WebURL() + "/login/oauth/access_token",
+		},
+		Scopes: []string{"repo", "workflow", "read:org"},
+	}
+}
+
+// EnableGitHubOAuth switches the github provider from the configured access
+// token to per-user clients built from the token of the logged in session.
+func (api *K8sService) EnableGitHubOAuth(config *oauth2.Config, host types.GitHubHost, sessionIdleTimeout time.Duration) {
+	api.OAuthConfig = config
+	api.OAuthHost = host
+	api.Sessions = types.NewSessionStore(sessionIdleTimeout)
+}
+
+func (api *K8sService) Login(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Login] starting service call")
+	if api.OAuthConfig == nil {
+		api.WriteHTTPErrorResponse(w, 404, fmt.Errorf("oauth login is not enabled"))
+		return
+	}
+
+	state, err := generateOAuthState()
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    state,
+		Path:     "/api/auth",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, api.OAuthConfig.AuthCodeURL(state), http.StatusFound)
+}
+
+func (api *K8sService) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	log.Println("[OAuthCallback] starting service call")
+	if api.OAuthConfig == nil {
+		api.WriteHTTPErrorResponse(w, 404, fmt.Errorf("oauth login is not enabled"))
+		return
+	}
+
+	ctx, err := api.OAuthHost.HTTPClientContext(context.Background())
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+
+	params := r.URL.Query()
+	stateCookie, err := r.Cookie(oauthStateCookieName)
+	if err != nil || stateCookie.Value == "" || stateCookie.Value != params.Get("state") {
+		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid oauth state"))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookieName, Path: "/api/auth", MaxAge: -1})
+
+	if params.Get("code") == "" {
+		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid code parameter"))
+		return
+	}
+
+	token, err := api.OAuthConfig.Exchange(ctx, params.Get("code"))
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 401, fmt.Errorf("failed to exchange oauth code: %s", err.Error()))
+		return
+	}
+
+	userClient, err := types.NewGithubUserClient(ctx, api.OAuthConfig.TokenSource(ctx, token), "", api.OAuthHost)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+
+	user, _, err := userClient.Users.Get(ctx, "")
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to fetch github user: %s", err.Error()))
+		return
+	}
+
+	session, err := api.Sessions.Create(user.GetLogin(), token)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.Println("[OAuthCallback] logged in: ", user.GetLogin())
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (api *K8sService) Logout(w http.ResponseWriter, r *http.Request) {
+	log.Println("[Logout] starting service call")
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && api.Sessions != nil {
+		api.Sessions.Delete(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *K8sService) CurrentUser(w http.ResponseWriter, r *http.Request) {
+	log.Println("[CurrentUser] starting service call")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if api.OAuthConfig == nil {
+		api.WriteHTTPErrorResponse(w, 404, fmt.Errorf("oauth login is not enabled"))
+		return
+	}
+
+	session, ok := api.getSession(r)
+	if !ok {
+		api.WriteHTTPErrorResponse(w, 401, errUnauthorized)
+		return
+	}
+
+	resp := types.UserResponse{
+		Data: &types.User{Login: session.UserLogin},
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+}
+
+func (api *K8sService) getSession(r *http.Request) (types.Session, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return types.Session{}, false
+	}
+
+	return api.Sessions.Get(cookie.Value)
+}
+
+// getUserGitHubClient builds a GitHub client for the session of the request.
+// Tokens refreshed while serving it are saved back to the session.
+func (api *K8sService) getUserGitHubClient(r *http.Request) (types.SourceControlProvider, error) {
+	session, ok := api.getSession(r)
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	ctx, err := api.OAuthHost.HTTPClientContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	tokenSource := api.Sessions.TokenSource(session, api.OAuthConfig.TokenSource(ctx, session.Token))
+	return types.NewGithubUserClient(ctx, tokenSource, session.UserLogin, api.OAuthHost)
+}
+
+func generateOAuthState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate oauth state: %s", err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"k8s-tooling-adapter/server/types"
+)
+
+func (api *K8sService) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	log.Println("[GetCacheStats] starting service call")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	stats, ok := types.GetObjectCacheStats()
+	if !ok {
+		api.WriteHTTPErrorResponse(w, 404, fmt.Errorf("object cache is disabled"))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(types.CacheStatsResponse{Data: &stats}); err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,11 +20,14 @@ import (
 	"github.com/google/go-github/v38/github"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes/scheme"
 )
 
 const (
 	charset = "abcdefghijklmnopqrstuvwxyz"
+
+	// maxManifestProbeBlobs caps the files downloaded to find the directories
+	// holding Kubernetes objects when listing manifest options.
+	maxManifestProbeBlobs = 200
 )
 
 func (api *K8sService) ListManifestOption(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +36,12 @@ func (api *K8sService) ListManifestOption(w http.ResponseWriter, r *http.Request
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -52,12 +63,20 @@ func (api *K8sService) ListManifestOption(w http.ResponseWriter, r *http.Request
 	repoBranch := params["repoBranch"][0]
 	log.Printf("[ListManifestOption] Req Repo: %s/%s/%s\n", repoOwner, repoName, repoBranch)
 
-	tree, err := api.GHClient.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
+	tree, err := provider.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
 		return
 	}
 
+	valuesFiles := make(map[string][]string)
+	for _, entry := range tree.Entries {
+		if entry != nil && entry.GetType() == "blob" && types.IsValuesFile(entry.GetPath()) {
+			dir := path.Dir(entry.GetPath())
+			valuesFiles[dir] = append(valuesFiles[dir], entry.GetPath())
+		}
+	}
+
 	manifestOptions := make([]*types.ManifestOption, 0)
 	for _, entry := range tree.Entries {
 		if entry == nil || entry.Path == nil {
@@ -66,23 +85,34 @@ func (api *K8sService) ListManifestOption(w http.ResponseWriter, r *http.Request
 
 		if strings.Contains(*entry.Path, "Chart.yaml") {
 			manifestOptions = append(manifestOptions, &types.ManifestOption{
-				SHA:  *entry.SHA,
-				Path: *entry.Path,
+				SHA:         *entry.SHA,
+				Path:        *entry.Path,
+				Type:        types.ManifestOptionHelm,
+				ValuesFiles: valuesFiles[path.Dir(*entry.Path)],
 			})
 		}
 
-		if strings.Contains(*entry.Path, "manifests") && *entry.Type == "tree" {
+		if types.IsKustomizationFile(*entry.Path) && *entry.Type == "blob" {
 			manifestOptions = append(manifestOptions, &types.ManifestOption{
 				SHA:  *entry.SHA,
 				Path: *entry.Path,
+				Type: types.ManifestOptionKustomize,
 			})
 		}
 	}
 
+	manifestDirs, partial, err := findManifestDirs(ctx, provider, repoOwner, repoName, tree)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+	manifestOptions = append(manifestOptions, manifestDirs...)
+
 	log.Println("[ListManifestOption] Found Manifest Options: ", len(manifestOptions))
 
 	resp := types.ManifestOptionResponse{
-		Data: manifestOptions,
+		Data:    manifestOptions,
+		Partial: tree.GetTruncated() || partial,
 	}
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -100,6 +130,12 @@ func (api *K8sService) ListRepoWorkflows(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -121,7 +157,7 @@ func (api *K8sService) ListRepoWorkflows(w http.ResponseWriter, r *http.Request)
 	repoBranch := params["repoBranch"][0]
 	log.Printf("[ListRepoWorkflows] Req Repo: %s/%s/%s\n", repoOwner, repoName, repoBranch)
 
-	tree, err := api.GHClient.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
+	tree, err := provider.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
 		return
@@ -149,7 +185,8 @@ func (api *K8sService) ListRepoWorkflows(w http.ResponseWriter, r *http.Request)
 	log.Println("[ListRepoWorkflows] Found Workflows: ", len(workflows))
 
 	resp := types.WorkflowResponse{
-		Data: workflows,
+		Data:    workflows,
+		Partial: tree.GetTruncated(),
 	}
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -167,6 +204,12 @@ func (api *K8sService) GetRepoAction(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -188,7 +231,7 @@ func (api *K8sService) GetRepoAction(w http.ResponseWriter, r *http.Request) {
 	repoBranch := params["repoBranch"][0]
 	log.Printf("[GetRepoAction] Req Repo: %s/%s/%s\n", repoOwner, repoName, repoBranch)
 
-	tree, err := api.GHClient.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
+	tree, err := provider.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
 		return
@@ -214,7 +257,12 @@ func (api *K8sService) GetRepoAction(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	blob, err := api.GHClient.GetBlob(ctx, repoOwner, repoName, actionYml.SHA)
+	if actionYml == nil {
+		api.WriteHTTPErrorResponse(w, 404, fmt.Errorf("no action.yml found in %s/%s", repoOwner, repoName))
+		return
+	}
+
+	blob, err := provider.GetBlob(ctx, repoOwner, repoName, actionYml.SHA)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
 		return
@@ -281,6 +329,12 @@ func (api *K8sService) GetWorkflowFile(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -301,7 +355,7 @@ func (api *K8sService) GetWorkflowFile(w http.ResponseWriter, r *http.Request) {
 	repoName := params["repoName"][0]
 	sha := params["sha"][0]
 
-	blob, err := api.GHClient.GetBlob(ctx, repoOwner, repoName, sha)
+	blob, err := provider.GetBlob(ctx, repoOwner, repoName, sha)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
 		return
@@ -333,6 +387,12 @@ func (api *K8sService) ListServices(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -359,51 +419,70 @@ func (api *K8sService) ListServices(w http.ResponseWriter, r *http.Request) {
 	repoBranch := params["repoBranch"][0]
 	manifestOptionPath := params["manifestOptionPath"][0]
 
-	manifestYamls := []string{}
-	var err error
-	if strings.Contains(manifestOptionPath, "Chart.yaml") {
+	renderOptions, err := types.CleanHelmRenderOptions(types.HelmRenderOptions{
+		ValuesFiles: params["valuesFile"],
+		SetValues:   params["set"],
+		ReleaseName: params.Get("releaseName"),
+		Namespace:   params.Get("namespace"),
+	})
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 400, err)
+		return
+	}
+
+	manifestObjects := []*types.ManifestObject{}
+	partial := false
+	if types.IsKustomizationFile(manifestOptionPath) {
+		log.Println("[ListServices] Using kustomization")
+		manifestObjects, err = api.getKustomizeManifestObjects(context.Background(), provider, repoName, repoOwner, repoBranch, manifestOptionPath)
+		if err != nil {
+			api.WriteHTTPErrorResponse(w, 500, err)
+			return
+		}
+	} else if strings.Contains(manifestOptionPath, "Chart.yaml") {
 		log.Println("[ListServices] Using Chart.yaml")
-		manifestYamls, err = api.getHelmManifestYamls(repoName, repoOwner, repoBranch, manifestOptionPath)
+		manifestObjects, err = api.getHelmManifestObjects(context.Background(), provider, repoName, repoOwner, repoBranch, manifestOptionPath, renderOptions)
 		if err != nil {
 			api.WriteHTTPErrorResponse(w, 500, err)
 			return
 		}
-	} else if strings.Contains(manifestOptionPath, "manifests") {
+	} else {
 		log.Println("[ListServices] Using manifests")
-		manifestYamls, err = api.getDefinedManifestYamls(context.Background(), repoName, repoOwner, repoBranch)
+		selector, err := types.CleanManifestSelector(types.ManifestSelector{
+			Dir:       manifestOptionPath,
+			Recursive: params.Get("recursive") == "true",
+			Include:   params["include"],
+			Exclude:   params["exclude"],
+		})
+		if err != nil {
+			api.WriteHTTPErrorResponse(w, 400, err)
+			return
+		}
+
+		manifestObjects, partial, err = api.getDefinedManifestObjects(context.Background(), provider, repoName, repoOwner, repoBranch, selector)
 		if err != nil {
 			api.WriteHTTPErrorResponse(w, 500, err)
 			return
 		}
 	}
 
-	decode := scheme.Codecs.UniversalDeserializer().Decode
 	services := make([]*types.Service, 0)
 
-	for _, yamlBlob := range manifestYamls {
-		if yamlBlob == "" {
-			continue
-		}
-
-		obj, _, err := decode([]byte(yamlBlob), nil, nil)
-		if err != nil {
-			fmt.Println("Error using universal decoder:", err.Error())
-			continue
-		}
-
-		if obj.GetObjectKind().GroupVersionKind().Kind == "Service" {
-			currService := obj.(*v1.Service)
+	for _, manifestObject := range manifestObjects {
+		if currService, ok := manifestObject.Object.(*v1.Service); ok {
 			services = append(services, &types.Service{
-				Name: currService.Name,
+				Name:   currService.Name,
+				Source: manifestObject.Source,
 			})
-			log.Println("[ListServices] found service: ", currService.Name)
+			log.Println("[ListServices] found service: ", currService.Name, " in ", manifestObject.Source)
 		}
 	}
 
 	log.Println("[ListServices] Num of services found: ", len(services))
 
 	resp := types.ServiceResponse{
-		Data: services,
+		Data:    services,
+		Partial: partial,
 	}
 
 	log.Println("[ListServices] Writing response")
@@ -424,6 +503,12 @@ func (api *K8sService) ListRepositoryWorkflows(w http.ResponseWriter, r *http.Re
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	ctx := context.Background()
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -444,13 +529,13 @@ func (api *K8sService) ListRepositoryWorkflows(w http.ResponseWriter, r *http.Re
 	repoName := params["repoName"][0]
 	branchSha := params["branchSha"][0]
 
-	tree, err := api.GHClient.GetBranchTree(ctx, repoOwner, repoName, branchSha)
+	tree, err := provider.GetBranchTree(ctx, repoOwner, repoName, branchSha)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("failed to get branch tree: %s", err.Error()))
 		return
 	}
 
-	workflowEntries := []*types.WorkflowDefinition{}
+	workflowBlobs := make([]*github.TreeEntry, 0)
 	for _, entry := range tree.Entries {
 		if entry == nil || entry.Path == nil {
 			continue
@@ -460,28 +545,44 @@ func (api *K8sService) ListRepositoryWorkflows(w http.ResponseWriter, r *http.Re
 
 		if strings.Contains(*entry.Path, ".github/") && (strings.Contains(*entry.Path, ".yaml") || strings.Contains(*entry.Path, ".yml")) && *entry.Type == "blob" {
 			log.Println("Using Path: ", *entry.Path)
-			blob, err := api.GHClient.GetBlob(ctx, repoOwner, repoName, *entry.SHA)
-			if err != nil {
-				api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to get blog: %s", err.Error()))
-				return
-			}
+			workflowBlobs = append(workflowBlobs, entry)
+		}
+	}
 
-			yamlString, err := base64.StdEncoding.DecodeString(*blob.Content)
-			if err != nil {
-				log.Println("failed to decode yaml: ", *entry.Path)
-				continue
-			}
+	// A workflow that cannot be fetched is left out rather than failing the
+	// whole list, the response is marked partial instead.
+	blobs, err := types.FetchBlobs(ctx, provider, repoOwner, repoName, treeEntrySHAs(workflowBlobs), types.BestEffort)
+	partial := tree.GetTruncated()
+	if err != nil {
+		if blobs == nil {
+			api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to get blob: %w", err))
+			return
+		}
+		log.Println("[ListRepositoryWorkflows] ", err.Error())
+		partial = true
+	}
 
-			workflowEntries = append(workflowEntries, &types.WorkflowDefinition{
-				SHA:          *entry.SHA,
-				Path:         *entry.Path,
-				WorkflowYaml: string(yamlString),
-			})
+	workflowEntries := []*types.WorkflowDefinition{}
+	for i, entry := range workflowBlobs {
+		if blobs[i] == nil {
+			continue
 		}
+
+		yamlString, err := base64.StdEncoding.DecodeString(blobs[i].GetContent())
+		if err != nil {
+			log.Println("failed to decode yaml: ", *entry.Path)
+			continue
+		}
+
+		workflowEntries = append(workflowEntries, &types.WorkflowDefinition{
+			SHA:          *entry.SHA,
+			Path:         *entry.Path,
+			WorkflowYaml: string(yamlString),
+		})
 	}
 
 	log.Println("[ListRepositoryWorkflows] Completed")
-	if err := json.NewEncoder(w).Encode(types.ListWorkflowResponse{Data: workflowEntries}); err != nil {
+	if err := json.NewEncoder(w).Encode(types.ListWorkflowResponse{Data: workflowEntries, Partial: partial}); err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
 		return
 	}
@@ -495,6 +596,12 @@ func (api *K8sService) GetChartDirectories(w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	ctx := context.Background()
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -523,7 +630,7 @@ func (api *K8sService) GetChartDirectories(w http.ResponseWriter, r *http.Reques
 
 	chartPath = strings.Replace(chartPath, "/Chart.yaml", "/", -1)
 
-	tree, err := api.GHClient.GetBranchTree(ctx, repoOwner, repoName, branchSha)
+	tree, err := provider.GetBranchTree(ctx, repoOwner, repoName, branchSha)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("failed to get branch tree: %s", err.Error()))
 		return
@@ -544,7 +651,7 @@ func (api *K8sService) GetChartDirectories(w http.ResponseWriter, r *http.Reques
 	}
 
 	log.Println("[GetChartDirectories] Completed")
-	if err := json.NewEncoder(w).Encode(types.TreeResponse{Data: directories}); err != nil {
+	if err := json.NewEncoder(w).Encode(types.TreeResponse{Data: directories, Partial: tree.GetTruncated()}); err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
 		return
 	}
@@ -559,6 +666,12 @@ func (api *K8sService) UpdateWorkflowPullRequest(w http.ResponseWriter, r *http.
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
 	ctx := context.Background()
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	log.Printf("[UpdateWorkflowPullRequest] request body: %v\n", r.Body)
 	requestDecoder := json.NewDecoder(r.Body)
 	defer func() {
@@ -568,7 +681,7 @@ func (api *K8sService) UpdateWorkflowPullRequest(w http.ResponseWriter, r *http.
 	}()
 
 	createPRRequest := &types.UpdateWorkflowPullRequest{}
-	err := requestDecoder.Decode(createPRRequest)
+	err = requestDecoder.Decode(createPRRequest)
 	if err != nil {
 		log.Println("[CreateIngressPullRequest] failed to decode request body")
 		api.WriteHTTPErrorResponse(w, 400, err)
@@ -576,38 +689,43 @@ func (api *K8sService) UpdateWorkflowPullRequest(w http.ResponseWriter, r *http.
 	}
 	log.Printf("[CreateIngressPullRequest] Request: %+v\n", createPRRequest)
 
-	newBranchName := generateBranchName(charset, 5)
-
-	fmt.Println("[UpdateWorkflowPullRequest] Getting repo reference")
-	ref, err := api.GHClient.GetReference(ctx, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName, createPRRequest.RepoBranch)
-	if err != nil || ref == nil {
-		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to make new commit ref"))
+	if createPRRequest.WorkflowFile == "" || createPRRequest.WorkflowDefinition == "" {
+		api.WriteHTTPErrorResponse(w, 409, fmt.Errorf("missing workflow file paramter or workflow definition parameter"))
 		return
 	}
 
-	newWorkflowFileName := ""
-	if createPRRequest.WorkflowFile == "" || createPRRequest.WorkflowDefinition == "" {
-		api.WriteHTTPErrorResponse(w, 409, fmt.Errorf("missing workflow file paramter or workflow definition parameter"))
+	createPRRequest.WorkflowFile, err = types.CleanWorkflowPath(createPRRequest.WorkflowFile)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 400, err)
 		return
 	}
 
-	fmt.Println("[UpdateWorkflowPullRequest] Saving workflow definition locally")
-	newWorkflowFileName, err = api.LocalRepoService.SaveFile(createPRRequest.RepoOwner, createPRRequest.RepoName, createPRRequest.RepoBranch, createPRRequest.WorkflowDefinition, createPRRequest.WorkflowFile)
+	changes, err := types.CleanFileChanges([]types.FileChange{
+		{Path: createPRRequest.WorkflowFile, Content: []byte(createPRRequest.WorkflowDefinition)},
+	})
 	if err != nil {
-		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to save file: %s", err))
+		api.WriteHTTPErrorResponse(w, 400, err)
+		return
+	}
+
+	newBranchName := generateBranchName(charset, 5)
+
+	fmt.Println("[UpdateWorkflowPullRequest] Getting repo reference")
+	ref, err := provider.GetReference(ctx, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName, createPRRequest.RepoBranch)
+	if err != nil || ref == nil {
+		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to make new commit ref"))
 		return
 	}
 
 	fmt.Println("[UpdateWorkflowPullRequest] Creating new commit tree")
-	sourceFile := fmt.Sprintf("%s:%s", newWorkflowFileName, createPRRequest.WorkflowFile)
-	tree, err := api.GHClient.GenerateCommitTree(ctx, ref, createPRRequest.RepoOwner, createPRRequest.RepoName, sourceFile)
+	tree, err := provider.GenerateCommitTree(ctx, ref, createPRRequest.RepoOwner, createPRRequest.RepoName, changes)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to generate new commit tree: %s", err.Error()))
 		return
 	}
 
 	fmt.Println("[UpdateWorkflowPullRequest] Creating new commit")
-	_, err = api.GHClient.CreateCommit(ctx, ref, tree, createPRRequest.RepoOwner, createPRRequest.RepoName)
+	_, err = provider.CreateCommit(ctx, ref, tree, createPRRequest.RepoOwner, createPRRequest.RepoName)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to create commit: %s", err.Error()))
 		return
@@ -615,7 +733,7 @@ func (api *K8sService) UpdateWorkflowPullRequest(w http.ResponseWriter, r *http.
 
 	fmt.Println("[UpdateWorkflowPullRequest] Generating pull request")
 	msg := "Updating Workflow"
-	pr, err := api.GHClient.CreatePullRequest(ctx, "Updating Workflow", createPRRequest.RepoOwner, createPRRequest.RepoName, createPRRequest.RepoBranch, msg, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName)
+	pr, err := provider.CreatePullRequest(ctx, "Updating Workflow", createPRRequest.RepoOwner, createPRRequest.RepoName, createPRRequest.RepoBranch, msg, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to create pull request: %s", err.Error()))
 		return
@@ -641,6 +759,12 @@ func (api *K8sService) CreateIngressPullRequest(w http.ResponseWriter, r *http.R
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
 	ctx := context.Background()
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	log.Printf("[CreateIngressPullRequest] request body: %v\n", r.Body)
 	requestDecoder := json.NewDecoder(r.Body)
 	defer func() {
@@ -650,7 +774,7 @@ func (api *K8sService) CreateIngressPullRequest(w http.ResponseWriter, r *http.R
 	}()
 
 	createPRRequest := &types.CreateIngressPullRequest{}
-	err := requestDecoder.Decode(createPRRequest)
+	err = requestDecoder.Decode(createPRRequest)
 	if err != nil {
 		log.Println("[CreateIngressPullRequest] failed to decode request body")
 		api.WriteHTTPErrorResponse(w, 400, err)
@@ -658,45 +782,48 @@ func (api *K8sService) CreateIngressPullRequest(w http.ResponseWriter, r *http.R
 	}
 	log.Printf("[CreateIngressPullRequest] Request: %+v\n", createPRRequest)
 
-	newBranchName := generateBranchName(charset, 5)
-
-	fmt.Println("[CreateIngressPullRequest] Getting repo reference")
-	ref, err := api.GHClient.GetReference(ctx, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName, createPRRequest.RepoBranch)
-	if err != nil || ref == nil {
-		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to make new commit ref"))
+	createPRRequest.IngressFilename, err = types.CleanRepoPath(createPRRequest.IngressFilename)
+	if err == nil {
+		createPRRequest.IngressDirectory, err = types.CleanRepoDir(createPRRequest.IngressDirectory)
+	}
+	if err == nil && createPRRequest.WorkflowFile != "" {
+		createPRRequest.WorkflowFile, err = types.CleanWorkflowPath(createPRRequest.WorkflowFile)
+	}
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 400, err)
 		return
 	}
 
-	fmt.Println("[CreateIngressPullRequest] Saving ingress definition localy")
-	newFileName, err := api.LocalRepoService.SaveFile(createPRRequest.RepoOwner, createPRRequest.RepoName, createPRRequest.RepoBranch, createPRRequest.IngressDefinition, createPRRequest.IngressFilename)
+	changes := []types.FileChange{
+		{Path: path.Join(createPRRequest.IngressDirectory, createPRRequest.IngressFilename), Content: []byte(createPRRequest.IngressDefinition)},
+	}
+	if createPRRequest.WorkflowFile != "" {
+		changes = append(changes, types.FileChange{Path: createPRRequest.WorkflowFile, Content: []byte(createPRRequest.WorkflowDefinition)})
+	}
+	changes, err = types.CleanFileChanges(changes)
 	if err != nil {
-		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to save file: %s", err))
+		api.WriteHTTPErrorResponse(w, 400, err)
 		return
 	}
 
-	newWorkflowFileName := ""
-	if createPRRequest.WorkflowFile != "" {
-		fmt.Println("[CreateIngressPullRequest] Saving workflow definition locally")
-		newWorkflowFileName, err = api.LocalRepoService.SaveFile(createPRRequest.RepoOwner, createPRRequest.RepoName, createPRRequest.RepoBranch, createPRRequest.WorkflowDefinition, createPRRequest.WorkflowFile)
-		if err != nil {
-			api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to save file: %s", err))
-			return
-		}
+	newBranchName := generateBranchName(charset, 5)
+
+	fmt.Println("[CreateIngressPullRequest] Getting repo reference")
+	ref, err := provider.GetReference(ctx, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName, createPRRequest.RepoBranch)
+	if err != nil || ref == nil {
+		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to make new commit ref"))
+		return
 	}
 
 	fmt.Println("[CreateIngressPullRequest] Creating new commit tree")
-	sourceFile := fmt.Sprintf("%s:%s", newFileName, createPRRequest.IngressDirectory+"/"+createPRRequest.IngressFilename)
-	if newWorkflowFileName != "" {
-		sourceFile = fmt.Sprintf("%s,%s:%s", sourceFile, newWorkflowFileName, createPRRequest.WorkflowFile)
-	}
-	tree, err := api.GHClient.GenerateCommitTree(ctx, ref, createPRRequest.RepoOwner, createPRRequest.RepoName, sourceFile)
+	tree, err := provider.GenerateCommitTree(ctx, ref, createPRRequest.RepoOwner, createPRRequest.RepoName, changes)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to generate new commit tree: %s", err.Error()))
 		return
 	}
 
 	fmt.Println("[CreateIngressPullRequest] Creating new commit")
-	_, err = api.GHClient.CreateCommit(ctx, ref, tree, createPRRequest.RepoOwner, createPRRequest.RepoName)
+	_, err = provider.CreateCommit(ctx, ref, tree, createPRRequest.RepoOwner, createPRRequest.RepoName)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to create commit: %s", err.Error()))
 		return
@@ -704,10 +831,10 @@ func (api *K8sService) CreateIngressPullRequest(w http.ResponseWriter, r *http.R
 
 	fmt.Println("[CreateIngressPullRequest] Generating pull request")
 	msg := "Adding ingress definition"
-	if newWorkflowFileName != "" {
+	if createPRRequest.WorkflowFile != "" {
 		msg = "Adding ingress definition and deployment to workflow"
 	}
-	pr, err := api.GHClient.CreatePullRequest(ctx, "Ingress Addition", createPRRequest.RepoOwner, createPRRequest.RepoName, createPRRequest.RepoBranch, msg, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName)
+	pr, err := provider.CreatePullRequest(ctx, "Ingress Addition", createPRRequest.RepoOwner, createPRRequest.RepoName, createPRRequest.RepoBranch, msg, createPRRequest.RepoOwner, createPRRequest.RepoName, newBranchName)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to create pull request: %s", err.Error()))
 		return
@@ -726,20 +853,60 @@ func (api *K8sService) CreateIngressPullRequest(w http.ResponseWriter, r *http.R
 
 }
 
-func (api *K8sService) getHelmManifestYamls(repoName, repoOwner, repoBranch, chartPath string) ([]string, error) {
-	err := api.LocalRepoService.CloneRepositoryLocaly(api.accessToken, repoOwner, repoName, repoBranch)
+func (api *K8sService) getHelmManifestObjects(ctx context.Context, provider types.SourceControlProvider, repoName, repoOwner, repoBranch, chartPath string, renderOptions types.HelmRenderOptions) ([]*types.ManifestObject, error) {
+	checkout, err := api.cloneRepository(ctx, provider, repoOwner, repoName, repoBranch)
 	if err != nil {
 		return nil, fmt.Errorf("error cloning repo: %s", err.Error())
 	}
+	defer checkout.Release()
 	log.Println("[ListServices] Cloned repo successfully")
 
-	helmManifest, err := api.LocalRepoService.GenerateManifestWithHelm(repoOwner, repoName, repoBranch, strings.Replace(chartPath, "/Chart.yaml", "/", -1))
+	helmManifest, err := api.LocalRepoService.GenerateManifestWithHelm(checkout, strings.Replace(chartPath, "/Chart.yaml", "/", -1), renderOptions)
 	if err != nil {
-		return nil, fmt.Errorf("error generating maifest: %s", err.Error())
+		return nil, fmt.Errorf("error generating maifest: %w", err)
 	}
 	log.Println("[ListServices] Manifest generated successfully")
 
-	return strings.Split(helmManifest, "---"), nil
+	return decodeManifests(chartPath, []byte(helmManifest)), nil
+}
+
+func (api *K8sService) getKustomizeManifestObjects(ctx context.Context, provider types.SourceControlProvider, repoName, repoOwner, repoBranch, kustomizationPath string) ([]*types.ManifestObject, error) {
+	checkout, err := api.cloneRepository(ctx, provider, repoOwner, repoName, repoBranch)
+	if err != nil {
+		return nil, fmt.Errorf("error cloning repo: %s", err.Error())
+	}
+	defer checkout.Release()
+	log.Println("[ListServices] Cloned repo successfully")
+
+	manifest, err := api.LocalRepoService.GenerateManifestWithKustomize(checkout, kustomizationPath)
+	if err != nil {
+		return nil, fmt.Errorf("error building kustomization: %w", err)
+	}
+	log.Println("[ListServices] Kustomization built successfully")
+
+	return decodeManifests(kustomizationPath, []byte(manifest)), nil
+}
+
+// decodeManifests decodes the objects in manifest, logging and skipping the
+// documents that cannot be decoded.
+func decodeManifests(source string, manifest []byte) []*types.ManifestObject {
+	objects, errs := types.DecodeManifests(source, manifest)
+	for _, err := range errs {
+		log.Println("[ListServices] skipping manifest document: ", err.Error())
+	}
+
+	return objects
+}
+
+// cloneRepository clones the branch locally, or brings an existing clone up to
+// the current head of the branch. The checkout is held until released.
+func (api *K8sService) cloneRepository(ctx context.Context, provider types.SourceControlProvider, repoOwner, repoName, repoBranch string) (*types.Checkout, error) {
+	branch, err := provider.GetRepositoryBranch(ctx, repoOwner, repoName, repoBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.LocalRepoService.CloneRepositoryLocaly(provider.CloneURL(repoOwner, repoName), repoOwner, repoName, repoBranch, branch.GetCommit().GetSHA())
 }
 
 func (api *K8sService) CreateActionPr(w http.ResponseWriter, r *http.Request) {
@@ -750,6 +917,12 @@ func (api *K8sService) CreateActionPr(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
 	ctx := context.Background()
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	log.Printf("[CreateActionPr] request body: %v\n", r.Body)
 	requestDecoder := json.NewDecoder(r.Body)
 	defer func() {
@@ -759,7 +932,7 @@ func (api *K8sService) CreateActionPr(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	createActionPr := &types.CreateActionPr{}
-	err := requestDecoder.Decode(createActionPr)
+	err = requestDecoder.Decode(createActionPr)
 	if err != nil {
 		log.Println("[CreateActionPr] failed to decode request body")
 		api.WriteHTTPErrorResponse(w, 400, err)
@@ -767,41 +940,37 @@ func (api *K8sService) CreateActionPr(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("[CreateActionPr] Request: %+v\n", createActionPr)
 
-	err = api.LocalRepoService.CloneRepositoryLocaly(api.accessToken, createActionPr.RepoOwner, createActionPr.RepoName, createActionPr.RepoBranch)
+	createActionPr.WorkflowFile, err = types.CleanWorkflowPath(createActionPr.WorkflowFile)
 	if err != nil {
-		log.Println("[GetRepoAction] failed to clone repo locally")
-		api.WriteHTTPErrorResponse(w, 500, err)
+		api.WriteHTTPErrorResponse(w, 400, err)
+		return
+	}
+
+	changes, err := types.CleanFileChanges([]types.FileChange{
+		{Path: createActionPr.WorkflowFile, Content: []byte(createActionPr.WorkflowDefinition)},
+	})
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 400, err)
 		return
 	}
 
 	newBranchName := generateBranchName(charset, 5)
 	fmt.Println("[CreateActionPr] Getting repo reference")
-	ref, err := api.GHClient.GetReference(ctx, createActionPr.RepoOwner, createActionPr.RepoName, newBranchName, createActionPr.RepoBranch)
+	ref, err := provider.GetReference(ctx, createActionPr.RepoOwner, createActionPr.RepoName, newBranchName, createActionPr.RepoBranch)
 	if err != nil || ref == nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to make new commit ref"))
 		return
 	}
 
-	newWorkflowFileName := ""
-	if createActionPr.WorkflowFile != "" {
-		fmt.Println("[CreateActionPr] Saving workflow definition locally")
-		newWorkflowFileName, err = api.LocalRepoService.SaveFile(createActionPr.RepoOwner, createActionPr.RepoName, createActionPr.RepoBranch, createActionPr.WorkflowDefinition, createActionPr.WorkflowFile)
-		if err != nil {
-			api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to save file: %s", err))
-			return
-		}
-	}
-
 	fmt.Println("[CreateActionPr] Creating new commit tree")
-	sourceFile := fmt.Sprintf("%s:%s", newWorkflowFileName, createActionPr.WorkflowFile)
-	tree, err := api.GHClient.GenerateCommitTree(ctx, ref, createActionPr.RepoOwner, createActionPr.RepoName, sourceFile)
+	tree, err := provider.GenerateCommitTree(ctx, ref, createActionPr.RepoOwner, createActionPr.RepoName, changes)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to generate new commit tree: %s", err.Error()))
 		return
 	}
 
 	fmt.Println("[CreateActionPr] Creating new commit")
-	_, err = api.GHClient.CreateCommit(ctx, ref, tree, createActionPr.RepoOwner, createActionPr.RepoName)
+	_, err = provider.CreateCommit(ctx, ref, tree, createActionPr.RepoOwner, createActionPr.RepoName)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to create commit: %s", err.Error()))
 		return
@@ -810,7 +979,7 @@ func (api *K8sService) CreateActionPr(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("[CreateActionPr] Generating pull request")
 	msg := "Adding action to workflow"
-	pr, err := api.GHClient.CreatePullRequest(ctx, "Action Workflow Addition", createActionPr.RepoOwner, createActionPr.RepoName, createActionPr.RepoBranch, msg, createActionPr.RepoOwner, createActionPr.RepoName, newBranchName)
+	pr, err := provider.CreatePullRequest(ctx, "Action Workflow Addition", createActionPr.RepoOwner, createActionPr.RepoName, createActionPr.RepoBranch, msg, createActionPr.RepoOwner, createActionPr.RepoName, newBranchName)
 	if err != nil {
 		api.WriteHTTPErrorResponse(w, 500, fmt.Errorf("failed to create pull request: %s", err.Error()))
 		return
@@ -827,16 +996,13 @@ func (api *K8sService) CreateActionPr(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (api *K8sService) getDefinedManifestYamls(ctx context.Context, repoName, repoOwner, repoBranch string) ([]string, error) {
-	err := api.LocalRepoService.CloneRepositoryLocaly(api.accessToken, repoOwner, repoName, repoBranch)
+// getDefinedManifestObjects decodes the manifest files chosen by selector. It
+// also reports whether the branch tree was too large to list completely, in
+// which case some manifests may be missing.
+func (api *K8sService) getDefinedManifestObjects(ctx context.Context, provider types.SourceControlProvider, repoName, repoOwner, repoBranch string, selector types.ManifestSelector) ([]*types.ManifestObject, bool, error) {
+	tree, err := provider.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
 	if err != nil {
-		return nil, fmt.Errorf("error cloning repo: %s", err.Error())
-	}
-	log.Println("[ListServices] Cloned repo successfully")
-
-	tree, err := api.GHClient.GetBranchTree(ctx, repoOwner, repoName, repoBranch)
-	if err != nil {
-		return nil, fmt.Errorf("error getting repo branch: %s", err.Error())
+		return nil, false, fmt.Errorf("error getting repo branch: %s", err.Error())
 	}
 
 	manifestYamlBlobs := make([]*github.TreeEntry, 0)
@@ -845,27 +1011,165 @@ func (api *K8sService) getDefinedManifestYamls(ctx context.Context, repoName, re
 			continue
 		}
 
-		if strings.Contains(*entry.Path, "manifests/") && strings.Contains(*entry.Path, ".yaml") && *entry.Type == "blob" {
+		if selector.Matches(*entry.Path) && *entry.Type == "blob" {
 			manifestYamlBlobs = append(manifestYamlBlobs, entry)
 		}
 	}
 
-	yamlBlobs := []string{}
-	for _, treeEntry := range manifestYamlBlobs {
-		blob, err := api.GHClient.GetBlob(ctx, repoOwner, repoName, *treeEntry.SHA)
+	blobs, err := types.FetchBlobs(ctx, provider, repoOwner, repoName, treeEntrySHAs(manifestYamlBlobs), types.FailFast)
+	if err != nil {
+		return nil, false, fmt.Errorf("error fetching manifests: %w", err)
+	}
+
+	manifestObjects := []*types.ManifestObject{}
+	for i, treeEntry := range manifestYamlBlobs {
+		manifest, err := base64.StdEncoding.DecodeString(blobs[i].GetContent())
 		if err != nil {
-			return nil, fmt.Errorf("error fetching blob %s: %s", *treeEntry.Path, err.Error())
+			log.Println("failed to decode manifest: ", *treeEntry.Path)
+			continue
 		}
+		manifestObjects = append(manifestObjects, decodeManifests(*treeEntry.Path, manifest)...)
+	}
 
-		yamlString, err := base64.StdEncoding.DecodeString(*blob.Content)
-		if err != nil {
-			log.Println("failed to decode yaml: ", *treeEntry.Path)
+	return manifestObjects, tree.GetTruncated(), nil
+}
+
+// findManifestDirs returns the directories of tree holding plain manifest files
+// that decode to at least one Kubernetes object, as manifest options sorted by
+// path. Chart templates, kustomizations, values files and workflows are not
+// considered. Files are classified lazily: a directory stops being probed once
+// one of its files matched, classifications are cached by blob SHA, and at
+// most maxManifestProbeBlobs files are downloaded. It also reports whether
+// some directories could not be classified.
+func findManifestDirs(ctx context.Context, provider types.SourceControlProvider, repoOwner, repoName string, tree *github.Tree) ([]*types.ManifestOption, bool, error) {
+	chartDirs := make(map[string]bool)
+	dirSHAs := map[string]string{".": tree.GetSHA()}
+	for _, entry := range tree.Entries {
+		if entry == nil || entry.Path == nil {
 			continue
 		}
-		yamlBlobs = append(yamlBlobs, string(yamlString))
+
+		if entry.GetType() == "tree" {
+			dirSHAs[entry.GetPath()] = entry.GetSHA()
+		} else if path.Base(entry.GetPath()) == "Chart.yaml" {
+			chartDirs[path.Dir(entry.GetPath())] = true
+		}
+	}
+
+	// The files of each directory left to classify, the directories in order.
+	pending := make(map[string][]*github.TreeEntry)
+	dirs := []string{}
+	found := make(map[string]bool)
+	for _, entry := range tree.Entries {
+		if entry == nil || entry.Path == nil || entry.GetType() != "blob" {
+			continue
+		}
+
+		filePath := entry.GetPath()
+		if !types.IsManifestFile(filePath) || types.IsKustomizationFile(filePath) || types.IsValuesFile(filePath) ||
+			path.Base(filePath) == "Chart.yaml" || strings.HasPrefix(filePath, ".github/") || types.IsChartTemplate(filePath, chartDirs) {
+			continue
+		}
+
+		dir := path.Dir(filePath)
+		if found[dir] {
+			continue
+		}
+
+		holdsObjects, known := types.CachedManifestBlob(entry.GetSHA())
+		if holdsObjects {
+			found[dir] = true
+			delete(pending, dir)
+			continue
+		}
+		if known {
+			continue
+		}
+
+		if _, ok := pending[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		pending[dir] = append(pending[dir], entry)
+	}
+
+	// Each round downloads the next file of every directory not matched yet.
+	partial := false
+	budget := maxManifestProbeBlobs
+	for budget > 0 {
+		probes := make([]*github.TreeEntry, 0)
+		for _, dir := range dirs {
+			if files := pending[dir]; !found[dir] && len(files) > 0 && len(probes) < budget {
+				probes = append(probes, files[0])
+				pending[dir] = files[1:]
+			}
+		}
+		if len(probes) == 0 {
+			break
+		}
+		budget -= len(probes)
+
+		// A file that cannot be fetched is left out, its directory may still be
+		// found through the others.
+		blobs, err := types.FetchBlobs(ctx, provider, repoOwner, repoName, treeEntrySHAs(probes), types.BestEffort)
+		if err != nil {
+			if blobs == nil {
+				return nil, false, fmt.Errorf("failed to get blob: %w", err)
+			}
+			log.Println("[ListManifestOption] ", err.Error())
+			partial = true
+		}
+
+		for i, entry := range probes {
+			if blobs[i] == nil {
+				continue
+			}
+
+			manifest, err := base64.StdEncoding.DecodeString(blobs[i].GetContent())
+			if err != nil {
+				log.Println("failed to decode manifest: ", entry.GetPath())
+				continue
+			}
+
+			objects, _ := types.DecodeManifests(entry.GetPath(), manifest)
+			types.CacheManifestBlob(entry.GetSHA(), len(objects) > 0)
+			if len(objects) > 0 {
+				found[path.Dir(entry.GetPath())] = true
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		if !found[dir] && len(pending[dir]) > 0 {
+			log.Printf("[ListManifestOption] Stopped after %d files, %s left unclassified\n", maxManifestProbeBlobs, dir)
+			partial = true
+		}
+	}
+
+	foundDirs := make([]string, 0, len(found))
+	for dir := range found {
+		foundDirs = append(foundDirs, dir)
+	}
+	sort.Strings(foundDirs)
+
+	manifestOptions := make([]*types.ManifestOption, 0, len(foundDirs))
+	for _, dir := range foundDirs {
+		manifestOptions = append(manifestOptions, &types.ManifestOption{
+			SHA:  dirSHAs[dir],
+			Path: dir,
+			Type: types.ManifestOptionManifests,
+		})
+	}
+
+	return manifestOptions, partial, nil
+}
+
+func treeEntrySHAs(entries []*github.TreeEntry) []string {
+	shas := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		shas = append(shas, entry.GetSHA())
 	}
 
-	return yamlBlobs, nil
+	return shas
 }
 
 func generateBranchName(charSet string, codeLength int32) string {
@@ -16,6 +16,12 @@ func (api *K8sService) ListRepoBranches(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
@@ -27,11 +33,37 @@ func (api *K8sService) ListRepoBranches(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	query, err := parseListQuery(params)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 400, err)
+		return
+	}
+
 	repoOwner := params["repoOwner"][0]
 	repoName := params["repoName"][0]
 	log.Printf("[ListRepoBranches] Req Repo: %s/%s\n", repoOwner, repoName)
 
-	branches, err := api.GHClient.ListRepositoryBranches(ctx, repoOwner, repoName)
+	if lister, ok := provider.(types.PageLister); ok && query.upstreamPage() {
+		branches, listPage, err := lister.ListRepositoryBranchesPage(ctx, repoOwner, repoName, query.page, query.perPage)
+		if err != nil {
+			log.Println("[ListRepoBranches] error fetching branches from github")
+			api.WriteHTTPErrorResponse(w, 500, err)
+			return
+		}
+
+		resp := types.BranchesResponse{}
+		resp.Data = types.GitHubToResponseBranch(branches)
+		resp.Pagination = query.upstreamPagination(listPage)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			api.WriteHTTPErrorResponse(w, 500, err)
+			return
+		}
+
+		log.Println("[ListRepoBranches] completed")
+		return
+	}
+
+	branches, err := provider.ListRepositoryBranches(ctx, repoOwner, repoName)
 	if err != nil {
 		log.Println("[ListRepoBranches] error fetching branches from github")
 		api.WriteHTTPErrorResponse(w, 500, err)
@@ -40,8 +72,17 @@ func (api *K8sService) ListRepoBranches(w http.ResponseWriter, r *http.Request)
 
 	log.Println("[ListRepoBranches] Branches found: ", len(branches))
 	log.Println("[ListRepoBranches] mutating branches for response")
+	matching := make([]*types.Branch, 0)
+	for _, branch := range types.GitHubToResponseBranch(branches) {
+		if query.matches(branch.Name) {
+			matching = append(matching, branch)
+		}
+	}
+
+	start, end := query.bounds(len(matching))
 	resp := types.BranchesResponse{}
-	resp.Data = types.GitHubToResponseBranch(branches)
+	resp.Data = matching[start:end]
+	resp.Pagination = query.pagination(len(matching))
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
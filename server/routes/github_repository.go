@@ -16,16 +16,48 @@ func (api *K8sService) ListRepositories(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
 	params := r.URL.Query()
 	if _, ok := params["repoOwner"]; !ok {
 		api.WriteHTTPErrorResponse(w, 400, fmt.Errorf("invalid repoOwner parameter"))
 		return
 	}
 
+	query, err := parseListQuery(params)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 400, err)
+		return
+	}
+
 	repoOwner := params["repoOwner"][0]
 	log.Printf("[ListRepositories] Req.Owner: %s\n", repoOwner)
 
-	repos, err := api.GHClient.ListRepositories(ctx, repoOwner)
+	if lister, ok := provider.(types.PageLister); ok && query.upstreamPage() {
+		repos, listPage, err := lister.ListRepositoriesPage(ctx, repoOwner, query.page, query.perPage)
+		if err != nil {
+			log.Println("[ListRepositories] error fetching repositories from github")
+			api.WriteHTTPErrorResponse(w, 500, err)
+			return
+		}
+
+		resp := types.RepositoriesResponse{}
+		resp.Data = types.GitHubToResponseRepository(repoOwner, repos)
+		resp.Pagination = query.upstreamPagination(listPage)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			api.WriteHTTPErrorResponse(w, 500, err)
+			return
+		}
+
+		log.Println("[ListRepositories] completed")
+		return
+	}
+
+	repos, err := provider.ListRepositories(ctx, repoOwner)
 	if err != nil {
 		log.Println("[ListRepositories] error fetching repositories from github")
 		api.WriteHTTPErrorResponse(w, 500, err)
@@ -34,8 +66,17 @@ func (api *K8sService) ListRepositories(w http.ResponseWriter, r *http.Request)
 
 	log.Println("[ListRepositories] Repositories found: ", len(repos))
 	log.Println("[ListRepositories] mutating repos for response")
+	matching := make([]*types.Repository, 0)
+	for _, repo := range types.GitHubToResponseRepository(repoOwner, repos) {
+		if query.matches(repo.Name) {
+			matching = append(matching, repo)
+		}
+	}
+
+	start, end := query.bounds(len(matching))
 	resp := types.RepositoriesResponse{}
-	resp.Data = types.GitHubToResponseRepository(repoOwner, repos)
+	resp.Data = matching[start:end]
+	resp.Pagination = query.pagination(len(matching))
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		api.WriteHTTPErrorResponse(w, 500, err)
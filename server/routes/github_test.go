@@ -0,0 +1,234 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"k8s-tooling-adapter/server/types"
+)
+
+const (
+	testOwner  = "owner"
+	testRepo   = "app"
+	testBranch = "main"
+)
+
+var testFiles = map[string]string{
+	"charts/web/Chart.yaml":             "apiVersion: v2\nname: web\nversion: 0.1.0\n",
+	"charts/web/values.yaml":            "port: 80\n",
+	"charts/web/values-prod.yaml":       "port: 443\n",
+	"charts/web/templates/service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: {{ .Release.Name }}-web\nspec:\n  ports:\n  - port: {{ .Values.port }}\n",
+	"overlays/dev/kustomization.yaml":   "resources:\n- service.yaml\nnamePrefix: dev-\n",
+	"overlays/dev/service.yaml":         "apiVersion: v1\nkind: Service\nmetadata:\n  name: api\n",
+	"deploy/service.yaml":               "apiVersion: v1\nkind: Service\nmetadata:\n  name: worker\n",
+	"deploy/notes.yaml":                 "notes: not a kubernetes object\n",
+	".github/workflows/build.yml":       "name: build\non: push\n",
+}
+
+// newTestService serves a fake provider, seeded with testFiles and two more
+// repositories, on the fake routes.
+func newTestService(t *testing.T) (*K8sService, *types.FakeProvider) {
+	t.Helper()
+	fakeProvider := types.NewFakeProvider()
+	t.Cleanup(func() { fakeProvider.Close() })
+
+	for _, repoName := range []string{testRepo, "docs", "infra"} {
+		if err := fakeProvider.AddRepository(testOwner, repoName, testBranch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fakeProvider.AddFiles(testOwner, testRepo, testBranch, testFiles, "add app"); err != nil {
+		t.Fatal(err)
+	}
+
+	lrs, err := types.NewLocalRepoService(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewK8sService(map[string]types.SourceControlProvider{"fake": fakeProvider}, lrs)
+	return api, fakeProvider
+}
+
+// serve calls handler with a request for target on the fake provider and
+// decodes the JSON response into v, failing unless it has the wanted status.
+func serve(t *testing.T, handler http.HandlerFunc, method, target string, body interface{}, wantStatus int, v interface{}) {
+	t.Helper()
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(method, target, &reqBody)
+	r = mux.SetURLVars(r, map[string]string{"provider": "fake"})
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code != wantStatus {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, target, wantStatus, w.Code, w.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, target, err)
+		}
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	api, _ := newTestService(t)
+
+	resp := types.RepositoriesResponse{}
+	serve(t, api.ListRepositories, "GET", "/api/fake/repositories?repoOwner=owner&page=2&perPage=2", nil, http.StatusOK, &resp)
+	if len(resp.Data) != 1 || resp.Data[0].Name != "infra" {
+		t.Errorf("expected the second page to hold infra, got %+v", resp.Data)
+	}
+	if resp.Pagination.TotalCount == nil || *resp.Pagination.TotalCount != 3 || resp.Pagination.HasMore ||
+		resp.Pagination.Page != 2 || resp.Pagination.PerPage != 2 {
+		t.Errorf("unexpected pagination %+v", resp.Pagination)
+	}
+
+	resp = types.RepositoriesResponse{}
+	serve(t, api.ListRepositories, "GET", "/api/fake/repositories?repoOwner=owner&q=DOC", nil, http.StatusOK, &resp)
+	if len(resp.Data) != 1 || resp.Data[0].Name != "docs" {
+		t.Errorf("expected the filter to match docs, got %+v", resp.Data)
+	}
+
+	serve(t, api.ListRepositories, "GET", "/api/fake/repositories", nil, http.StatusBadRequest, nil)
+}
+
+func TestListRepoBranches(t *testing.T) {
+	api, fakeProvider := newTestService(t)
+	if _, err := fakeProvider.GetReference(context.Background(), testOwner, testRepo, "feature", testBranch); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := types.BranchesResponse{}
+	serve(t, api.ListRepoBranches, "GET", "/api/fake/repository/branches?repoOwner=owner&repoName=app", nil, http.StatusOK, &resp)
+	names := []string{}
+	for _, branch := range resp.Data {
+		names = append(names, branch.Name)
+	}
+	if strings.Join(names, ",") != "feature,main" {
+		t.Errorf("expected branches feature and main, got %v", names)
+	}
+}
+
+func TestListManifestOption(t *testing.T) {
+	api, _ := newTestService(t)
+
+	resp := types.ManifestOptionResponse{}
+	serve(t, api.ListManifestOption, "GET", "/api/fake/repository/charts?repoOwner=owner&repoName=app&repoBranch=main", nil, http.StatusOK, &resp)
+	if resp.Partial {
+		t.Error("expected a complete listing")
+	}
+
+	options := map[string]*types.ManifestOption{}
+	for _, option := range resp.Data {
+		options[option.Type+" "+option.Path] = option
+	}
+
+	chart, ok := options[types.ManifestOptionHelm+" charts/web/Chart.yaml"]
+	if !ok {
+		t.Fatalf("chart not listed, got %v", options)
+	}
+	sort.Strings(chart.ValuesFiles)
+	if strings.Join(chart.ValuesFiles, ",") != "charts/web/values-prod.yaml,charts/web/values.yaml" {
+		t.Errorf("unexpected values files %v", chart.ValuesFiles)
+	}
+	if _, ok := options[types.ManifestOptionKustomize+" overlays/dev/kustomization.yaml"]; !ok {
+		t.Errorf("kustomization not listed, got %v", options)
+	}
+	// The resources of a kustomization decode to objects as well.
+	for _, dir := range []string{"deploy", "overlays/dev"} {
+		if _, ok := options[types.ManifestOptionManifests+" "+dir]; !ok {
+			t.Errorf("manifests directory %s not listed, got %v", dir, options)
+		}
+	}
+	if len(options) != 4 {
+		t.Errorf("expected four options, got %v", options)
+	}
+}
+
+func TestListServices(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  types.Service
+	}{
+		{
+			name:  "manifests",
+			query: "manifestOptionPath=deploy",
+			want:  types.Service{Name: "worker", Source: "deploy/service.yaml"},
+		},
+		{
+			name:  "kustomize",
+			query: "manifestOptionPath=overlays/dev/kustomization.yaml",
+			want:  types.Service{Name: "dev-api", Source: "overlays/dev/kustomization.yaml"},
+		},
+		{
+			name:  "helm",
+			query: "manifestOptionPath=charts/web/Chart.yaml&releaseName=shop",
+			want:  types.Service{Name: "shop-web", Source: "web/templates/service.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Charts are rendered from a clone of the fake, which go-git makes
+			// with git-upload-pack.
+			if _, err := exec.LookPath("git"); err != nil && test.name != "manifests" {
+				t.Skip("git is not installed")
+			}
+
+			api, _ := newTestService(t)
+			resp := types.ServiceResponse{}
+			serve(t, api.ListServices, "GET", "/api/fake/repository/services?repoOwner=owner&repoName=app&repoBranch=main&"+test.query, nil, http.StatusOK, &resp)
+			if len(resp.Data) != 1 || *resp.Data[0] != test.want {
+				t.Errorf("expected service %+v, got %+v", test.want, resp.Data)
+			}
+		})
+	}
+}
+
+func TestCreateIngressPullRequest(t *testing.T) {
+	api, fakeProvider := newTestService(t)
+
+	request := types.CreateIngressPullRequest{
+		RepoOwner:         testOwner,
+		RepoName:          testRepo,
+		RepoBranch:        testBranch,
+		IngressDefinition: "kind: Ingress\n",
+		IngressDirectory:  "deploy",
+		IngressFilename:   "ingress.yaml",
+	}
+	resp := types.CreatePullRequestResponse{}
+	serve(t, api.CreateIngressPullRequest, "POST", "/api/fake/repository/pr", request, http.StatusOK, &resp)
+
+	pullRequests := fakeProvider.PullRequests(testOwner, testRepo)
+	if len(pullRequests) != 1 || pullRequests[0].GetHTMLURL() != resp.PullRequestURL {
+		t.Fatalf("expected one pull request at %s, got %v", resp.PullRequestURL, pullRequests)
+	}
+
+	headBranch := pullRequests[0].GetHead().GetRef()
+	if contents, ok := fakeProvider.GetFile(testOwner, testRepo, headBranch, "deploy/ingress.yaml"); !ok || contents != request.IngressDefinition {
+		t.Errorf("expected the ingress to be committed to %s, got %q", headBranch, contents)
+	}
+	if _, ok := fakeProvider.GetFile(testOwner, testRepo, testBranch, "deploy/ingress.yaml"); ok {
+		t.Error("expected the base branch to be left alone")
+	}
+
+	request.IngressFilename = "../escape.yaml"
+	serve(t, api.CreateIngressPullRequest, "POST", "/api/fake/repository/pr", request, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"k8s-tooling-adapter/server/types"
+)
+
+const (
+	defaultPerPage = 30
+	maxPerPage     = 100
+)
+
+// listQuery holds the server side paging and name filtering of the list
+// endpoints, read from the page, perPage and q parameters. Without page or
+// perPage every matching entry is returned.
+type listQuery struct {
+	page    int
+	perPage int
+	filter  string
+}
+
+func parseListQuery(params url.Values) (listQuery, error) {
+	q := listQuery{
+		page:   1,
+		filter: strings.ToLower(strings.TrimSpace(params.Get("q"))),
+	}
+
+	if value := params.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return q, fmt.Errorf("invalid page parameter")
+		}
+		q.page = page
+		q.perPage = defaultPerPage
+	}
+
+	if value := params.Get("perPage"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return q, fmt.Errorf("invalid perPage parameter, must be between 1 and %d", maxPerPage)
+		}
+		q.perPage = perPage
+	}
+
+	return q, nil
+}
+
+// upstreamPage reports whether the request asks for a single page without a
+// filter, which providers implementing types.PageLister fetch upstream as is.
+func (q listQuery) upstreamPage() bool {
+	return q.perPage > 0 && q.filter == ""
+}
+
+// matches reports whether name contains the filter, ignoring case.
+func (q listQuery) matches(name string) bool {
+	return q.filter == "" || strings.Contains(strings.ToLower(name), q.filter)
+}
+
+// bounds returns the slice bounds of the requested page out of total entries.
+func (q listQuery) bounds(total int) (int, int) {
+	if q.perPage == 0 {
+		return 0, total
+	}
+
+	start := (q.page - 1) * q.perPage
+	if start > total {
+		start = total
+	}
+	end := start + q.perPage
+	if end > total {
+		end = total
+	}
+
+	return start, end
+}
+
+func (q listQuery) pagination(total int) *types.Pagination {
+	perPage := q.perPage
+	if perPage == 0 {
+		perPage = total
+	}
+	_, end := q.bounds(total)
+
+	return &types.Pagination{
+		Page:       q.page,
+		PerPage:    perPage,
+		TotalCount: &total,
+		HasMore:    end < total,
+	}
+}
+
+// upstreamPagination describes a page fetched upstream, leaving the total out
+// when the provider did not report it.
+func (q listQuery) upstreamPagination(listPage types.ListPage) *types.Pagination {
+	pagination := &types.Pagination{
+		Page:    q.page,
+		PerPage: q.perPage,
+		HasMore: listPage.HasMore,
+	}
+	if listPage.TotalKnown {
+		total := listPage.TotalCount
+		pagination.TotalCount = &total
+	}
+
+	return pagination
+}
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"k8s-tooling-adapter/server/types"
+)
+
+type servedProviderKey struct{}
+
+// servedProvider records the provider resolved by getProvider for a request,
+// so its quota can be reported once the handler writes the response.
+type servedProvider struct {
+	provider types.SourceControlProvider
+}
+
+// RateLimitHeaders is a middleware adding the X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset headers reported by GitHub to
+// the responses of providers that track their quota.
+func (api *K8sService) RateLimitHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
+		served := &servedProvider{}
+		ctx := context.WithValue(r.Context(), servedProviderKey{}, served)
+		next.ServeHTTP(&rateLimitResponseWriter{ResponseWriter: w, served: served}, r.WithContext(ctx))
+	})
+}
+
+func recordServedProvider(r *http.Request, provider types.SourceControlProvider) {
+	if served, ok := r.Context().Value(servedProviderKey{}).(*servedProvider); ok {
+		served.provider = provider
+	}
+}
+
+type rateLimitResponseWriter struct {
+	http.ResponseWriter
+	served      *servedProvider
+	wroteHeader bool
+}
+
+func (rw *rateLimitResponseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		if reporter, ok := rw.served.provider.(types.RateLimitReporter); ok {
+			if rate, known := reporter.LastRate(); known {
+				rw.Header().Set("X-RateLimit-Limit", strconv.Itoa(rate.Limit))
+				rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(rate.Remaining))
+				rw.Header().Set("X-RateLimit-Reset", strconv.FormatInt(rate.Reset.Unix(), 10))
+			}
+		}
+	}
+
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *rateLimitResponseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+func (api *K8sService) GetRateLimit(w http.ResponseWriter, r *http.Request) {
+	log.Println("[GetRateLimit] starting service call")
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	provider, err := api.getProvider(r)
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, providerErrorStatus(err), err)
+		return
+	}
+
+	reporter, ok := provider.(types.RateLimitReporter)
+	if !ok {
+		api.WriteHTTPErrorResponse(w, 404, fmt.Errorf("provider does not report a rate limit"))
+		return
+	}
+
+	rate, err := reporter.RateLimit(ctx, r.URL.Query().Get("repoOwner"))
+	if err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+
+	resp := types.RateLimitResponse{
+		Data: &types.RateLimit{
+			Limit:     rate.Limit,
+			Remaining: rate.Remaining,
+			Reset:     rate.Reset.Time,
+		},
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		api.WriteHTTPErrorResponse(w, 500, err)
+		return
+	}
+
+	log.Println("[GetRateLimit] completed")
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v38/github"
+)
+
+// blobFetchWorkers bounds the concurrent blob downloads of one FetchBlobs call.
+const blobFetchWorkers = 8
+
+type BlobFetchMode int
+
+const (
+	// FailFast stops fetching at the first error and returns it.
+	FailFast BlobFetchMode = iota
+	// BestEffort fetches every blob, leaving failed ones nil, and returns the
+	// failures together as one error.
+	BestEffort
+)
+
+type BlobGetter interface {
+	GetBlob(ctx context.Context, repoOwner, repoName, blobSHA string) (*github.Blob, error)
+}
+
+// FetchBlobs downloads blobSHAs with a bounded pool of workers. The result
+// holds one blob per SHA in the same order, whatever order they finish in.
+func FetchBlobs(ctx context.Context, getter BlobGetter, repoOwner, repoName string, blobSHAs []string, mode BlobFetchMode) ([]*github.Blob, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	blobs := make([]*github.Blob, len(blobSHAs))
+	errs := make([]error, len(blobSHAs))
+	indexes := make(chan int)
+
+	// In FailFast mode the first failure cancels the others, which then fail
+	// with the cancellation, so only the first one is reported.
+	var firstErr error
+	var failOnce sync.Once
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < blobFetchWorkers && worker < len(blobSHAs); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				blob, err := getter.GetBlob(ctx, repoOwner, repoName, blobSHAs[i])
+				if err != nil {
+					errs[i] = fmt.Errorf("blob %s: %w", blobSHAs[i], err)
+					if mode == FailFast {
+						failOnce.Do(func() {
+							firstErr = errs[i]
+							cancel()
+						})
+					}
+					continue
+				}
+				blobs[i] = blob
+			}
+		}()
+	}
+
+feed:
+	for i := range blobSHAs {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, fmt.Errorf("[FetchBlobs] error fetching %w", firstErr)
+	}
+
+	var failures []string
+	for _, err := range errs {
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	// Only the caller can have cancelled the context at this point.
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("[FetchBlobs] %w", err)
+	}
+
+	if len(failures) > 0 {
+		return blobs, fmt.Errorf("[FetchBlobs] failed to fetch %d of %d blobs: %s", len(failures), len(blobSHAs), strings.Join(failures, "; "))
+	}
+
+	return blobs, nil
+}
@@ -0,0 +1,755 @@
+package types
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/google/go-github/v38/github"
+)
+
+// FakeProvider is an in-memory SourceControlProvider. Repositories are seeded
+// with AddRepository and AddFile, or LoadFixtures, and every commit and pull
+// request created through the provider is kept so callers can inspect the
+// result. Objects are also written to a bare git repository per repository,
+// so the SHAs are those git computes and repositories can be cloned from
+// CloneURL to render charts. Close removes the bare repositories.
+type FakeProvider struct {
+	mu    sync.Mutex
+	repos map[string]*fakeRepository
+	// gitDir holds the bare repositories, created on first use.
+	gitDir string
+}
+
+type fakeRepository struct {
+	repo     *github.Repository
+	branches map[string]string
+	commits  map[string]*fakeCommit
+	trees    map[string]map[string]fakeTreeFile
+	// treeDirs maps a tree to the SHAs of its subdirectories.
+	treeDirs     map[string]map[string]string
+	blobs        map[string][]byte
+	pullRequests []*github.PullRequest
+	// gitPath is the bare repository objects and branches are written to.
+	gitPath string
+	objects storer.Storer
+}
+
+// fakeTreeFile is a file of a stored tree.
+type fakeTreeFile struct {
+	blobSHA string
+	mode    string
+}
+
+type fakeCommit struct {
+	sha     string
+	treeSHA string
+	parent  string
+	message string
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		repos: make(map[string]*fakeRepository),
+	}
+}
+
+// AddRepository registers an empty repository with a single default branch.
+func (fp *FakeProvider) AddRepository(repoOwner, repoName, defaultBranch string) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.gitDir == "" {
+		gitDir, err := ioutil.TempDir("", "k8s-tools-fake-")
+		if err != nil {
+			return fmt.Errorf("[AddRepository] failed to create git directory: %s", err.Error())
+		}
+		fp.gitDir = gitDir
+	}
+
+	gitPath := filepath.Join(fp.gitDir, fmt.Sprintf("%d.git", len(fp.repos)+1))
+	gitRepo, err := git.PlainInit(gitPath, true)
+	if err != nil {
+		return fmt.Errorf("[AddRepository] failed to create git repository: %s", err.Error())
+	}
+
+	repo := &fakeRepository{
+		repo: &github.Repository{
+			ID:            github.Int64(int64(len(fp.repos) + 1)),
+			Name:          github.String(repoName),
+			FullName:      github.String(repoOwner + "/" + repoName),
+			Owner:         &github.User{Login: github.String(repoOwner)},
+			DefaultBranch: github.String(defaultBranch),
+		},
+		branches: make(map[string]string),
+		commits:  make(map[string]*fakeCommit),
+		trees:    make(map[string]map[string]fakeTreeFile),
+		treeDirs: make(map[string]map[string]string),
+		blobs:    make(map[string][]byte),
+		gitPath:  gitPath,
+		objects:  gitRepo.Storer,
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(defaultBranch))
+	if err := repo.objects.SetReference(head); err != nil {
+		return fmt.Errorf("[AddRepository] failed to set HEAD: %s", err.Error())
+	}
+
+	treeSHA, err := repo.storeTree(map[string]fakeTreeFile{})
+	if err != nil {
+		return fmt.Errorf("[AddRepository] %s", err.Error())
+	}
+	commitSHA, err := repo.storeCommit(treeSHA, "", "initial commit")
+	if err != nil {
+		return fmt.Errorf("[AddRepository] %s", err.Error())
+	}
+	if err := repo.setBranch(defaultBranch, commitSHA); err != nil {
+		return fmt.Errorf("[AddRepository] %s", err.Error())
+	}
+
+	fp.repos[fakeRepoKey(repoOwner, repoName)] = repo
+	return nil
+}
+
+// AddFile commits a single file to the given branch of a seeded repository.
+func (fp *FakeProvider) AddFile(repoOwner, repoName, repoBranch, filePath, contents string) error {
+	return fp.AddFiles(repoOwner, repoName, repoBranch, map[string]string{filePath: contents}, "add "+filePath)
+}
+
+// AddFiles commits the files, keyed by their path, to the given branch of a
+// seeded repository in a single commit.
+func (fp *FakeProvider) AddFiles(repoOwner, repoName, repoBranch string, files map[string]string, message string) error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return err
+	}
+
+	head, ok := repo.branches[repoBranch]
+	if !ok {
+		return fmt.Errorf("[AddFiles] branch %s does not exist", repoBranch)
+	}
+
+	treeFiles := repo.copyFiles(repo.commits[head].treeSHA)
+	for filePath, contents := range files {
+		blobSHA, err := repo.storeBlob([]byte(contents))
+		if err != nil {
+			return fmt.Errorf("[AddFiles] %s", err.Error())
+		}
+		treeFiles[filePath] = fakeTreeFile{blobSHA: blobSHA, mode: FileModeRegular}
+	}
+
+	treeSHA, err := repo.storeTree(treeFiles)
+	if err != nil {
+		return fmt.Errorf("[AddFiles] %s", err.Error())
+	}
+	commitSHA, err := repo.storeCommit(treeSHA, head, message)
+	if err != nil {
+		return fmt.Errorf("[AddFiles] %s", err.Error())
+	}
+
+	return repo.setBranch(repoBranch, commitSHA)
+}
+
+// LoadFixtures seeds a repository for every <owner>/<repo> directory in dir,
+// with the files under it committed to a main branch.
+func (fp *FakeProvider) LoadFixtures(dir string) error {
+	owners, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("[LoadFixtures] failed to read fixtures: %s", err.Error())
+	}
+
+	for _, owner := range owners {
+		if !owner.IsDir() {
+			continue
+		}
+
+		repos, err := ioutil.ReadDir(filepath.Join(dir, owner.Name()))
+		if err != nil {
+			return fmt.Errorf("[LoadFixtures] failed to read fixtures: %s", err.Error())
+		}
+
+		for _, repo := range repos {
+			if !repo.IsDir() {
+				continue
+			}
+
+			repoDir := filepath.Join(dir, owner.Name(), repo.Name())
+			files, err := readFixtureFiles(repoDir)
+			if err != nil {
+				return fmt.Errorf("[LoadFixtures] failed to read %s: %s", repoDir, err.Error())
+			}
+
+			if err := fp.AddRepository(owner.Name(), repo.Name(), fakeFixtureBranch); err != nil {
+				return err
+			}
+			if len(files) == 0 {
+				continue
+			}
+			if err := fp.AddFiles(owner.Name(), repo.Name(), fakeFixtureBranch, files, "add fixtures"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readFixtureFiles returns the regular files under dir keyed by their slash
+// separated path relative to dir, skipping .git directories.
+func readFixtureFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(relPath)] = string(contents)
+		return nil
+	})
+
+	return files, err
+}
+
+// Close removes the bare repositories backing the provider.
+func (fp *FakeProvider) Close() error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.gitDir == "" {
+		return nil
+	}
+
+	return os.RemoveAll(fp.gitDir)
+}
+
+// GetFile returns the contents of a file at the head of the given branch.
+func (fp *FakeProvider) GetFile(repoOwner, repoName, repoBranch, filePath string) (string, bool) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return "", false
+	}
+
+	head, ok := repo.branches[repoBranch]
+	if !ok {
+		return "", false
+	}
+
+	file, ok := repo.trees[repo.commits[head].treeSHA][filePath]
+	if !ok {
+		return "", false
+	}
+
+	return string(repo.blobs[file.blobSHA]), true
+}
+
+// PullRequests returns the pull requests opened against the given repository.
+func (fp *FakeProvider) PullRequests(repoOwner, repoName string) []*github.PullRequest {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return nil
+	}
+
+	return append([]*github.PullRequest{}, repo.pullRequests...)
+}
+
+func (fp *FakeProvider) ListRepositories(ctx context.Context, repoOwner string) ([]*github.Repository, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repos := make([]*github.Repository, 0)
+	for _, repo := range fp.repos {
+		if repo.repo.GetOwner().GetLogin() == repoOwner {
+			repos = append(repos, repo.repo)
+		}
+	}
+
+	sort.Slice(repos, func(i, j int) bool { return repos[i].GetName() < repos[j].GetName() })
+	return repos, nil
+}
+
+func (fp *FakeProvider) ListRepositoryBranches(ctx context.Context, repoOwner, repoName string) ([]*github.Branch, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[ListRepositoryBranches] error fetching branches %s: %s", repoName, err.Error())
+	}
+
+	branches := make([]*github.Branch, 0)
+	for name, sha := range repo.branches {
+		branches = append(branches, &github.Branch{
+			Name:   github.String(name),
+			Commit: &github.RepositoryCommit{SHA: github.String(sha)},
+		})
+	}
+
+	sort.Slice(branches, func(i, j int) bool { return branches[i].GetName() < branches[j].GetName() })
+	return branches, nil
+}
+
+func (fp *FakeProvider) GetRepositoryBranch(ctx context.Context, repoOwner, repoName, branchName string) (*github.Branch, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[GetRepositoryBranch] error fetching branch %s: %s", branchName, err.Error())
+	}
+
+	sha, ok := repo.branches[branchName]
+	if !ok {
+		return nil, fmt.Errorf("[GetRepositoryBranch] error fetching branch %s: not found", branchName)
+	}
+
+	return &github.Branch{
+		Name:   github.String(branchName),
+		Commit: &github.RepositoryCommit{SHA: github.String(sha)},
+	}, nil
+}
+
+// GetBranchTree accepts a branch name, commit SHA or tree SHA, mirroring the
+// GitHub API, and always returns the recursive tree.
+func (fp *FakeProvider) GetBranchTree(ctx context.Context, repoOwner, repoName, branchSHA string) (*github.Tree, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s", err.Error())
+	}
+
+	treeSHA := branchSHA
+	if head, ok := repo.branches[branchSHA]; ok {
+		treeSHA = repo.commits[head].treeSHA
+	} else if commit, ok := repo.commits[branchSHA]; ok {
+		treeSHA = commit.treeSHA
+	}
+
+	if _, ok := repo.trees[treeSHA]; !ok {
+		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s not found", branchSHA)
+	}
+
+	return repo.buildTree(treeSHA), nil
+}
+
+func (fp *FakeProvider) GetBlob(ctx context.Context, repoOwner, repoName, blobSHA string) (*github.Blob, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[GetBlob] error fetching blob: %s", err.Error())
+	}
+
+	contents, ok := repo.blobs[blobSHA]
+	if !ok {
+		return nil, fmt.Errorf("[GetBlob] error fetching blob: %s not found", blobSHA)
+	}
+
+	return &github.Blob{
+		SHA:      github.String(blobSHA),
+		Content:  github.String(base64.StdEncoding.EncodeToString(contents)),
+		Encoding: github.String("base64"),
+		Size:     github.Int(len(contents)),
+	}, nil
+}
+
+func (fp *FakeProvider) GetReference(ctx context.Context, sourceOwner, sourceRepo, commitBranch, baseBranch string) (*github.Reference, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(sourceOwner, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if sha, ok := repo.branches[commitBranch]; ok {
+		return fakeReference(commitBranch, sha), nil
+	}
+
+	if commitBranch == baseBranch {
+		return nil, fmt.Errorf("The commit branch does not exist but `-base-branch` is the same as `-commit-branch`")
+	}
+
+	if baseBranch == "" {
+		return nil, fmt.Errorf("The `-base-branch` should not be set to an empty string when the branch specified by `-commit-branch` does not exists")
+	}
+
+	baseSHA, ok := repo.branches[baseBranch]
+	if !ok {
+		return nil, fmt.Errorf("[GetReference] base branch %s does not exist", baseBranch)
+	}
+
+	if err := repo.setBranch(commitBranch, baseSHA); err != nil {
+		return nil, fmt.Errorf("[GetReference] %s", err.Error())
+	}
+
+	return fakeReference(commitBranch, baseSHA), nil
+}
+
+// GenerateCommitTree applies the changes on top of the tree of ref.
+func (fp *FakeProvider) GenerateCommitTree(ctx context.Context, ref *github.Reference, sourceOwner, sourceRepo string, changes []FileChange) (*github.Tree, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(sourceOwner, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, ok := repo.commits[ref.GetObject().GetSHA()]
+	if !ok {
+		return nil, fmt.Errorf("[GenerateCommitTree] commit %s not found", ref.GetObject().GetSHA())
+	}
+
+	files := repo.copyFiles(parent.treeSHA)
+	for _, change := range changes {
+		if change.Delete {
+			delete(files, change.Path)
+			continue
+		}
+		blobSHA, err := repo.storeBlob(change.Content)
+		if err != nil {
+			return nil, fmt.Errorf("[GenerateCommitTree] %s", err.Error())
+		}
+		files[change.Path] = fakeTreeFile{blobSHA: blobSHA, mode: change.Mode}
+	}
+
+	treeSHA, err := repo.storeTree(files)
+	if err != nil {
+		return nil, fmt.Errorf("[GenerateCommitTree] %s", err.Error())
+	}
+
+	return repo.buildTree(treeSHA), nil
+}
+
+func (fp *FakeProvider) CreateCommit(ctx context.Context, ref *github.Reference, tree *github.Tree, sourceOwner, sourceRepo string) (*github.Commit, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(sourceOwner, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := repo.trees[tree.GetSHA()]; !ok {
+		return nil, fmt.Errorf("[CreateCommit] tree %s not found", tree.GetSHA())
+	}
+
+	sha, err := repo.storeCommit(tree.GetSHA(), ref.GetObject().GetSHA(), commitMessage)
+	if err != nil {
+		return nil, fmt.Errorf("[CreateCommit] %s", err.Error())
+	}
+	if err := repo.setBranch(strings.TrimPrefix(ref.GetRef(), "refs/heads/"), sha); err != nil {
+		return nil, fmt.Errorf("[CreateCommit] %s", err.Error())
+	}
+	ref.Object.SHA = github.String(sha)
+
+	date := time.Now()
+	return &github.Commit{
+		SHA:     github.String(sha),
+		Message: github.String(commitMessage),
+		Tree:    tree,
+		Author:  &github.CommitAuthor{Date: &date, Name: &commitName, Email: &commitEmail},
+	}, nil
+}
+
+func (fp *FakeProvider) CreatePullRequest(ctx context.Context, prSubject, prRepoOwner, prRepo, prBranch, prDescription, sourceOwner, sourceRepo, commitBranch string) (*github.PullRequest, error) {
+	if prSubject == "" {
+		return nil, errors.New("missing `-pr-title` flag; skipping PR creation")
+	}
+
+	if prRepoOwner == "" {
+		prRepoOwner = sourceOwner
+	}
+
+	if prRepo == "" {
+		prRepo = sourceRepo
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(prRepoOwner, prRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := repo.branches[prBranch]; !ok {
+		return nil, fmt.Errorf("[CreatePullRequest] base branch %s does not exist", prBranch)
+	}
+
+	number := len(repo.pullRequests) + 1
+	pr := &github.PullRequest{
+		Number:  github.Int(number),
+		State:   github.String("open"),
+		Title:   github.String(prSubject),
+		Body:    github.String(prDescription),
+		HTMLURL: github.String(fmt.Sprintf("https://fake.local/%s/%s/pull/%d", prRepoOwner, prRepo, number)),
+		Head:    &github.PullRequestBranch{Ref: github.String(commitBranch)},
+		Base:    &github.PullRequestBranch{Ref: github.String(prBranch)},
+	}
+
+	repo.pullRequests = append(repo.pullRequests, pr)
+	return pr, nil
+}
+
+// CloneURL returns the file:// URL of the bare repository backing the
+// repository, or an empty string if there is no such repository.
+func (fp *FakeProvider) CloneURL(repoOwner, repoName string) string {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	repo, err := fp.getRepo(repoOwner, repoName)
+	if err != nil {
+		return ""
+	}
+
+	return "file://" + filepath.ToSlash(repo.gitPath)
+}
+
+func (fp *FakeProvider) getRepo(repoOwner, repoName string) (*fakeRepository, error) {
+	repo, ok := fp.repos[fakeRepoKey(repoOwner, repoName)]
+	if !ok {
+		return nil, fmt.Errorf("repository %s/%s not found", repoOwner, repoName)
+	}
+
+	return repo, nil
+}
+
+func (repo *fakeRepository) copyFiles(treeSHA string) map[string]fakeTreeFile {
+	files := make(map[string]fakeTreeFile)
+	for filePath, file := range repo.trees[treeSHA] {
+		files[filePath] = file
+	}
+
+	return files
+}
+
+// storeObject writes an object to the bare repository and returns its SHA.
+func (repo *fakeRepository) storeObject(objectType plumbing.ObjectType, encode func(plumbing.EncodedObject) error) (string, error) {
+	obj := repo.objects.NewEncodedObject()
+	obj.SetType(objectType)
+	if err := encode(obj); err != nil {
+		return "", fmt.Errorf("failed to encode %s: %s", objectType, err.Error())
+	}
+
+	hash, err := repo.objects.SetEncodedObject(obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to store %s: %s", objectType, err.Error())
+	}
+
+	return hash.String(), nil
+}
+
+func (repo *fakeRepository) storeBlob(contents []byte) (string, error) {
+	sha, err := repo.storeObject(plumbing.BlobObject, func(obj plumbing.EncodedObject) error {
+		w, err := obj.Writer()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(contents); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	repo.blobs[sha] = contents
+	return sha, nil
+}
+
+// storeTree writes the git trees of the files, one per directory, and returns
+// the SHA of the root tree.
+func (repo *fakeRepository) storeTree(files map[string]fakeTreeFile) (string, error) {
+	dirs := make(map[string]string)
+	sha, err := repo.storeDir("", files, dirs)
+	if err != nil {
+		return "", err
+	}
+
+	repo.trees[sha] = files
+	repo.treeDirs[sha] = dirs
+	return sha, nil
+}
+
+// storeDir writes the tree of the directory dir, "" for the root, recording
+// the SHAs of the trees written for its subdirectories in dirs.
+func (repo *fakeRepository) storeDir(dir string, files map[string]fakeTreeFile, dirs map[string]string) (string, error) {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	tree := &object.Tree{}
+	subdirs := make(map[string]bool)
+	for filePath, file := range files {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(filePath, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			subdirs[name[:i]] = true
+			continue
+		}
+
+		mode := filemode.Regular
+		if file.mode == FileModeExecutable {
+			mode = filemode.Executable
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: mode, Hash: plumbing.NewHash(file.blobSHA)})
+	}
+
+	for name := range subdirs {
+		sha, err := repo.storeDir(prefix+name, files, dirs)
+		if err != nil {
+			return "", err
+		}
+		dirs[prefix+name] = sha
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: plumbing.NewHash(sha)})
+	}
+
+	// Git orders the entries of a tree by name, directories as if they ended
+	// with a slash.
+	sortKey := func(entry object.TreeEntry) string {
+		if entry.Mode == filemode.Dir {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool { return sortKey(tree.Entries[i]) < sortKey(tree.Entries[j]) })
+
+	return repo.storeObject(plumbing.TreeObject, tree.Encode)
+}
+
+func (repo *fakeRepository) storeCommit(treeSHA, parent, message string) (string, error) {
+	signature := object.Signature{Name: commitName, Email: commitEmail, When: time.Now()}
+	commit := &object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   message,
+		TreeHash:  plumbing.NewHash(treeSHA),
+	}
+	if parent != "" {
+		commit.ParentHashes = []plumbing.Hash{plumbing.NewHash(parent)}
+	}
+
+	sha, err := repo.storeObject(plumbing.CommitObject, commit.Encode)
+	if err != nil {
+		return "", err
+	}
+
+	repo.commits[sha] = &fakeCommit{
+		sha:     sha,
+		treeSHA: treeSHA,
+		parent:  parent,
+		message: message,
+	}
+
+	return sha, nil
+}
+
+// setBranch points the branch at the commit sha.
+func (repo *fakeRepository) setBranch(branch, sha string) error {
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), plumbing.NewHash(sha))
+	if err := repo.objects.SetReference(ref); err != nil {
+		return fmt.Errorf("failed to update branch %s: %s", branch, err.Error())
+	}
+
+	repo.branches[branch] = sha
+	return nil
+}
+
+// buildTree flattens a stored tree into the shape of a recursive GitHub tree,
+// including an entry for every intermediate directory.
+func (repo *fakeRepository) buildTree(treeSHA string) *github.Tree {
+	files := repo.trees[treeSHA]
+	directories := repo.treeDirs[treeSHA]
+	entries := make([]*github.TreeEntry, 0, len(files)+len(directories))
+	for filePath, file := range files {
+		entries = append(entries, &github.TreeEntry{
+			SHA:  github.String(file.blobSHA),
+			Path: github.String(filePath),
+			Type: github.String("blob"),
+			Mode: github.String(file.mode),
+			Size: github.Int(len(repo.blobs[file.blobSHA])),
+		})
+	}
+
+	for dir, sha := range directories {
+		entries = append(entries, &github.TreeEntry{
+			SHA:  github.String(sha),
+			Path: github.String(dir),
+			Type: github.String("tree"),
+			Mode: github.String("040000"),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].GetPath() < entries[j].GetPath() })
+	return &github.Tree{
+		SHA:       github.String(treeSHA),
+		Entries:   entries,
+		Truncated: github.Bool(false),
+	}
+}
+
+func fakeReference(branch, sha string) *github.Reference {
+	return &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha), Type: github.String("commit")},
+	}
+}
+
+// fakeFixtureBranch is the branch fixtures are committed to.
+const fakeFixtureBranch = "main"
+
+func fakeRepoKey(repoOwner, repoName string) string {
+	return repoOwner + "/" + repoName
+}
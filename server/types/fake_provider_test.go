@@ -0,0 +1,51 @@
+package types
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFakeProviderLoadFixtures(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"owner/app/charts/web/Chart.yaml": "apiVersion: v2\nname: web\n",
+		"owner/app/README.md":             "app\n",
+		"owner/docs/index.md":             "docs\n",
+	}
+	for filePath, contents := range files {
+		fullPath := filepath.Join(dir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fp := NewFakeProvider()
+	defer fp.Close()
+	if err := fp.LoadFixtures(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := fp.ListRepositories(context.Background(), "owner")
+	if err != nil || len(repos) != 2 {
+		t.Fatalf("expected two repositories, got %v, %v", repos, err)
+	}
+
+	if contents, ok := fp.GetFile("owner", "app", fakeFixtureBranch, "charts/web/Chart.yaml"); !ok || contents != files["owner/app/charts/web/Chart.yaml"] {
+		t.Errorf("fixture not committed, got %q", contents)
+	}
+
+	tree, err := fp.GetBranchTree(context.Background(), "owner", "app", fakeFixtureBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range tree.Entries {
+		if !isObjectSHA(entry.GetSHA()) {
+			t.Errorf("entry %s has no object SHA: %q", entry.GetPath(), entry.GetSHA())
+		}
+	}
+}
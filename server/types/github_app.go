@@ -0,0 +1,420 @@
+package types
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v38/github"
+	"golang.org/x/oauth2"
+)
+
+// installationTokenRefreshMargin is how long before expiry an installation
+// token is replaced, so a token handed to a clone never expires mid-request.
+const installationTokenRefreshMargin = 5 * time.Minute
+
+// installationCacheTTL is how long the installation found on an owner is
+// used before it is looked up again, in case the app was reinstalled.
+const installationCacheTTL = time.Hour
+
+// GitHubAppProvider implements SourceControlProvider as a GitHub App. Every
+// call is routed to a client authenticated with an installation token for the
+// installation on the repository owner.
+type GitHubAppProvider struct {
+	appClient *github.Client
+	host      GitHubHost
+	// rate is shared by the installation clients.
+	rate *rateState
+
+	mu      sync.Mutex
+	clients map[string]*cachedInstallation
+	// lookups holds the installation lookups in flight, keyed like clients.
+	lookups map[string]*installationLookup
+}
+
+// cachedInstallation is the client of the installation found on an owner.
+type cachedInstallation struct {
+	client *GitHubClient
+	found  time.Time
+}
+
+// installationLookup is a lookup of the installation on an owner, shared by
+// the requests for the owner made while it is in flight.
+type installationLookup struct {
+	done   chan struct{}
+	client *GitHubClient
+	err    error
+	// canceled is set when the request making the lookup went away, so those
+	// waiting for it look the installation up again.
+	canceled bool
+}
+
+// NewGithubAppProvider authenticates as the app identified by appID on the
+// given host using its PEM encoded private key.
+func NewGithubAppProvider(appID int64, privateKeyPEM []byte, host GitHubHost) (*GitHubAppProvider, error) {
+	privateKey, err := parseRSAPrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("[NewGithubAppProvider] invalid private key: %s", err.Error())
+	}
+
+	base, err := host.transport()
+	if err != nil {
+		return nil, fmt.Errorf("[NewGithubAppProvider] %s", err.Error())
+	}
+
+	log.Println("Creating Github App client for: ", host.GetCloneHost())
+	transport := &appTransport{
+		appID:      appID,
+		privateKey: privateKey,
+		base:       base,
+	}
+
+	appClient, err := host.newClient(&http.Client{Transport: transport})
+	if err != nil {
+		return nil, fmt.Errorf("[NewGithubAppProvider] %s", err.Error())
+	}
+
+	return &GitHubAppProvider{
+		appClient: appClient,
+		host:      host,
+		rate:      &rateState{},
+		clients:   make(map[string]*cachedInstallation),
+		lookups:   make(map[string]*installationLookup),
+	}, nil
+}
+
+// ClientForOwner returns the installation client for repoOwner, finding the
+// installation on first use and again once installationCacheTTL has passed or
+// GitHub rejected its credentials. The lock is only held to read and store
+// clients: requests for other owners are not held up by a lookup, and those
+// for the same owner wait for the lookup in flight.
+func (gap *GitHubAppProvider) ClientForOwner(ctx context.Context, repoOwner string) (*GitHubClient, error) {
+	key := strings.ToLower(repoOwner)
+
+	for {
+		gap.mu.Lock()
+		if cached, ok := gap.clients[key]; ok && time.Since(cached.found) < installationCacheTTL {
+			gap.mu.Unlock()
+			return cached.client, nil
+		}
+
+		if lookup, ok := gap.lookups[key]; ok {
+			gap.mu.Unlock()
+			select {
+			case <-lookup.done:
+			case <-ctx.Done():
+				return nil, fmt.Errorf("[ClientForOwner] %s", ctx.Err().Error())
+			}
+			if lookup.canceled {
+				continue
+			}
+			return lookup.client, lookup.err
+		}
+
+		lookup := &installationLookup{done: make(chan struct{})}
+		gap.lookups[key] = lookup
+		gap.mu.Unlock()
+
+		lookup.client, lookup.err = gap.newInstallationClient(ctx, repoOwner)
+		lookup.canceled = lookup.err != nil && ctx.Err() != nil
+
+		gap.mu.Lock()
+		delete(gap.lookups, key)
+		if lookup.err == nil {
+			gap.clients[key] = &cachedInstallation{client: lookup.client, found: time.Now()}
+		}
+		gap.mu.Unlock()
+
+		close(lookup.done)
+		return lookup.client, lookup.err
+	}
+}
+
+// forget drops client, the cached client for the owner key, so the next
+// request looks the installation up again.
+func (gap *GitHubAppProvider) forget(key string, client *GitHubClient) {
+	gap.mu.Lock()
+	defer gap.mu.Unlock()
+
+	if cached, ok := gap.clients[key]; ok && cached.client == client {
+		log.Println("[ClientForOwner] Dropping rejected installation for: ", key)
+		delete(gap.clients, key)
+	}
+}
+
+// newInstallationClient finds the installation on repoOwner and returns a
+// client authenticated as it. The client is forgotten once GitHub answers it
+// with 401 Unauthorized, or refuses it a token because the installation is
+// gone.
+func (gap *GitHubAppProvider) newInstallationClient(ctx context.Context, repoOwner string) (*GitHubClient, error) {
+	installation, resp, err := gap.appClient.Apps.FindOrganizationInstallation(ctx, repoOwner)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		installation, resp, err = gap.appClient.Apps.FindUserInstallation(ctx, repoOwner)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ClientForOwner] no app installation found for %s: %s", repoOwner, err.Error())
+	}
+
+	log.Printf("[ClientForOwner] Using installation %d for %s\n", installation.GetID(), repoOwner)
+	var client *GitHubClient
+	forget := func() { gap.forget(strings.ToLower(repoOwner), client) }
+	ts := oauth2.ReuseTokenSource(nil, &installationTokenSource{
+		appClient:      gap.appClient,
+		installationID: installation.GetID(),
+		forget:         forget,
+	})
+
+	httpCtx, err := gap.host.HTTPClientContext(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("[ClientForOwner] %s", err.Error())
+	}
+
+	httpClient := oauth2.NewClient(httpCtx, ts)
+	httpClient.Transport = &installationTransport{base: httpClient.Transport, forget: forget}
+	installationClient, err := gap.host.newClient(httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("[ClientForOwner] %s", err.Error())
+	}
+
+	client = &GitHubClient{
+		Client:       installationClient,
+		tokenSource:  ts,
+		cloneHost:    gap.host.GetCloneHost(),
+		rate:         gap.rate,
+		installation: true,
+	}
+	return client, nil
+}
+
+func (gap *GitHubAppProvider) ListRepositories(ctx context.Context, repoOwner string) ([]*github.Repository, error) {
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.ListRepositories(ctx, repoOwner)
+}
+
+func (gap *GitHubAppProvider) ListRepositoryBranches(ctx context.Context, repoOwner, repoName string) ([]*github.Branch, error) {
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.ListRepositoryBranches(ctx, repoOwner, repoName)
+}
+
+func (gap *GitHubAppProvider) ListRepositoriesPage(ctx context.Context, repoOwner string, page, perPage int) ([]*github.Repository, ListPage, error) {
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, ListPage{}, err
+	}
+
+	return client.ListRepositoriesPage(ctx, repoOwner, page, perPage)
+}
+
+func (gap *GitHubAppProvider) ListRepositoryBranchesPage(ctx context.Context, repoOwner, repoName string, page, perPage int) ([]*github.Branch, ListPage, error) {
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, ListPage{}, err
+	}
+
+	return client.ListRepositoryBranchesPage(ctx, repoOwner, repoName, page, perPage)
+}
+
+func (gap *GitHubAppProvider) GetRepositoryBranch(ctx context.Context, repoOwner, repoName, branchName string) (*github.Branch, error) {
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetRepositoryBranch(ctx, repoOwner, repoName, branchName)
+}
+
+func (gap *GitHubAppProvider) GetBranchTree(ctx context.Context, repoOwner, repoName, branchSHA string) (*github.Tree, error) {
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetBranchTree(ctx, repoOwner, repoName, branchSHA)
+}
+
+func (gap *GitHubAppProvider) GetBlob(ctx context.Context, repoOwner, repoName, blobSHA string) (*github.Blob, error) {
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetBlob(ctx, repoOwner, repoName, blobSHA)
+}
+
+func (gap *GitHubAppProvider) GetReference(ctx context.Context, sourceOwner, sourceRepo, commitBranch, baseBranch string) (*github.Reference, error) {
+	client, err := gap.ClientForOwner(ctx, sourceOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetReference(ctx, sourceOwner, sourceRepo, commitBranch, baseBranch)
+}
+
+func (gap *GitHubAppProvider) GenerateCommitTree(ctx context.Context, ref *github.Reference, sourceOwner, sourceRepo string, changes []FileChange) (*github.Tree, error) {
+	client, err := gap.ClientForOwner(ctx, sourceOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GenerateCommitTree(ctx, ref, sourceOwner, sourceRepo, changes)
+}
+
+func (gap *GitHubAppProvider) CreateCommit(ctx context.Context, ref *github.Reference, tree *github.Tree, sourceOwner, sourceRepo string) (*github.Commit, error) {
+	client, err := gap.ClientForOwner(ctx, sourceOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CreateCommit(ctx, ref, tree, sourceOwner, sourceRepo)
+}
+
+func (gap *GitHubAppProvider) CreatePullRequest(ctx context.Context, prSubject, prRepoOwner, prRepo, prBranch, prDescription, sourceOwner, sourceRepo, commitBranch string) (*github.PullRequest, error) {
+	client, err := gap.ClientForOwner(ctx, sourceOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CreatePullRequest(ctx, prSubject, prRepoOwner, prRepo, prBranch, prDescription, sourceOwner, sourceRepo, commitBranch)
+}
+
+func (gap *GitHubAppProvider) CloneURL(repoOwner, repoName string) string {
+	client, err := gap.ClientForOwner(context.Background(), repoOwner)
+	if err != nil {
+		log.Println("[CloneURL] ", err.Error())
+		return ""
+	}
+
+	return client.CloneURL(repoOwner, repoName)
+}
+
+// installationTokenSource mints installation access tokens. It is wrapped in
+// an oauth2.ReuseTokenSource, which only calls it again once the token is
+// within the refresh margin of its expiry.
+type installationTokenSource struct {
+	appClient      *github.Client
+	installationID int64
+	// forget is called when the installation turns out to be gone.
+	forget func()
+}
+
+func (its *installationTokenSource) Token() (*oauth2.Token, error) {
+	log.Println("[InstallationToken] Minting token for installation: ", its.installationID)
+	token, resp, err := its.appClient.Apps.CreateInstallationToken(context.Background(), its.installationID, nil)
+	if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotFound) {
+		its.forget()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[InstallationToken] error creating installation token: %s", err.Error())
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "token",
+		Expiry:      token.GetExpiresAt().Add(-installationTokenRefreshMargin),
+	}, nil
+}
+
+// installationTransport calls forget when a request made with an
+// installation token is answered with 401 Unauthorized.
+type installationTransport struct {
+	base   http.RoundTripper
+	forget func()
+}
+
+func (it *installationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := it.base.RoundTrip(req)
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		it.forget()
+	}
+
+	return resp, err
+}
+
+// appTransport authenticates requests as the app itself with a short lived
+// RS256 signed JWT, as required for the installation endpoints.
+type appTransport struct {
+	appID      int64
+	privateKey *rsa.PrivateKey
+	base       http.RoundTripper
+}
+
+func (at *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jwt, err := at.signedJWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	return at.base.RoundTrip(req)
+}
+
+func (at *appTransport) signedJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	// Backdate the issue time to allow for clock drift, GitHub rejects tokens
+	// valid for longer than ten minutes.
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": at.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, at.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("[appTransport] error signing jwt: %s", err.Error())
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parseRSAPrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
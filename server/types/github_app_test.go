@@ -0,0 +1,109 @@
+package types
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitHubApp answers the installation endpoints of the app and, unless
+// revoked, branch lookups made with an installation token.
+type fakeGitHubApp struct {
+	mu      sync.Mutex
+	lookups int
+	revoked bool
+}
+
+func (fa *fakeGitHubApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/api/v3/orgs/owner/installation":
+		fa.lookups++
+		fa.revoked = false
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": fa.lookups})
+	case r.Method == http.MethodPost && r.URL.Path == fmt.Sprintf("/api/v3/app/installations/%d/access_tokens", fa.lookups):
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": "token", "expires_at": time.Now().Add(time.Hour)})
+	case r.URL.Path == "/api/v3/repos/owner/repo/branches/main" && !fa.revoked:
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "main", "commit": map[string]string{"sha": "0123456789012345678901234567890123456789"}})
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Bad credentials"})
+	}
+}
+
+func (fa *fakeGitHubApp) lookupCount() int {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	return fa.lookups
+}
+
+func TestGitHubAppForgetsRejectedInstallation(t *testing.T) {
+	fakeApp := &fakeGitHubApp{}
+	server := httptest.NewServer(fakeApp)
+	defer server.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	host, err := NewGitHubHost(GitHubHost{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gap, err := NewGithubAppProvider(1, keyPEM, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := gap.GetRepositoryBranch(ctx, "owner", "repo", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gap.GetRepositoryBranch(ctx, "owner", "repo", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups := fakeApp.lookupCount(); lookups != 1 {
+		t.Fatalf("expected the installation to be looked up once, got %d", lookups)
+	}
+
+	// The app was reinstalled: the old token is rejected once, then the new
+	// installation is found.
+	fakeApp.mu.Lock()
+	fakeApp.revoked = true
+	fakeApp.mu.Unlock()
+	if _, err := gap.GetRepositoryBranch(ctx, "owner", "repo", "main"); err == nil {
+		t.Fatal("expected the revoked token to be rejected")
+	}
+	if _, err := gap.GetRepositoryBranch(ctx, "owner", "repo", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups := fakeApp.lookupCount(); lookups != 2 {
+		t.Fatalf("expected the installation to be looked up again, got %d lookups", lookups)
+	}
+
+	// Installations are looked up again once they are older than the TTL.
+	gap.mu.Lock()
+	gap.clients["owner"].found = time.Now().Add(-installationCacheTTL)
+	gap.mu.Unlock()
+	if _, err := gap.ClientForOwner(ctx, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups := fakeApp.lookupCount(); lookups != 3 {
+		t.Errorf("expected an expired installation to be looked up again, got %d lookups", lookups)
+	}
+}
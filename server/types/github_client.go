@@ -4,9 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -18,49 +18,282 @@ var (
 	commitName    = "bfoley13"
 	commitEmail   = "brandonfoley13@gmail.com"
 	commitMessage = "k8s-ingress-extension"
+
+	// maxListPages caps how many pages a list call follows, zero means no cap.
+	maxListPages = 0
 )
 
+const (
+	// listPerPage is the page size requested from GitHub, the maximum it allows.
+	listPerPage = 100
+
+	// maxTreeWalkRequests caps the directories fetched when walking a tree that
+	// was too large to list recursively.
+	maxTreeWalkRequests = 500
+)
+
+// ConfigureCommitAuthor sets the identity and message used for generated
+// commits. It must be called before any provider is used.
+func ConfigureCommitAuthor(name, email, message string) {
+	commitName = name
+	commitEmail = email
+	commitMessage = message
+}
+
+// ConfigureListPageLimit caps the number of pages followed by list calls, so
+// very large organizations cannot stall a request. Zero removes the cap.
+func ConfigureListPageLimit(maxPages int) {
+	maxListPages = maxPages
+}
+
+// nextListPage returns the page to fetch after resp, or zero once the last
+// page or the page limit has been reached.
+func nextListPage(resp *github.Response, fetched int) int {
+	if resp == nil || resp.NextPage == 0 {
+		return 0
+	}
+
+	if maxListPages > 0 && fetched >= maxListPages {
+		log.Printf("[nextListPage] Page limit of %d reached, results are truncated\n", maxListPages)
+		return 0
+	}
+
+	return resp.NextPage
+}
+
+// pageTotal returns the number of entries across all pages of a list, given
+// page, which holds count entries and was answered with resp. When resp links
+// to a last page, that page is fetched with fetchPage to count its entries.
+func pageTotal(resp *github.Response, page, perPage, count int, fetchPage func(page int) (int, *github.Response, error)) (int, error) {
+	lastPage := 0
+	if resp != nil {
+		lastPage = resp.LastPage
+	}
+	if lastPage == 0 && (count > 0 || page == 1) {
+		return (page-1)*perPage + count, nil
+	}
+
+	// A page past the end links back to the first page only.
+	if lastPage == 0 {
+		firstCount, firstResp, err := fetchPage(1)
+		if err != nil {
+			return 0, err
+		}
+		if firstResp == nil || firstResp.LastPage == 0 {
+			return firstCount, nil
+		}
+		lastPage = firstResp.LastPage
+	}
+
+	lastCount, _, err := fetchPage(lastPage)
+	if err != nil {
+		return 0, err
+	}
+
+	return (lastPage-1)*perPage + lastCount, nil
+}
+
 type GitHubClient struct {
 	*github.Client
+	tokenSource oauth2.TokenSource
+	cloneHost   string
+	rate        *rateState
+	// userLogin is set when the client acts on behalf of a logged in user
+	// rather than with the configured access token.
+	userLogin string
+	// installation is set when the client is authenticated as a GitHub App
+	// installation.
+	installation bool
 }
 
-func NewGithubClient(ctx context.Context, accessToken string) GitHubClient {
-	if accessToken == "" {
-		return GitHubClient{
-			github.NewClient(nil),
-		}
+// NewGithubClient creates a client for the given host, authenticated with
+// accessToken when it is set.
+func NewGithubClient(ctx context.Context, accessToken string, host GitHubHost) (*GitHubClient, error) {
+	ctx, err := host.HTTPClientContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("[NewGithubClient] %s", err.Error())
 	}
 
-	log.Println("Setting up auth...")
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: accessToken},
-	)
-	tc := oauth2.NewClient(ctx, ts)
+	tc := oauth2.NewClient(ctx, nil)
+	var ts oauth2.TokenSource
+	if accessToken != "" {
+		log.Println("Setting up auth...")
+		RedactSecret(accessToken)
+		ts = oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: accessToken},
+		)
+		tc = oauth2.NewClient(ctx, ts)
+	}
 
-	log.Println("Creating Github client...")
-	return GitHubClient{
-		github.NewClient(tc),
+	log.Println("Creating Github client for: ", host.GetCloneHost())
+	client, err := host.newClient(tc)
+	if err != nil {
+		return nil, fmt.Errorf("[NewGithubClient] %s", err.Error())
 	}
+
+	return &GitHubClient{
+		Client:      client,
+		tokenSource: ts,
+		cloneHost:   host.GetCloneHost(),
+		rate:        &rateState{},
+	}, nil
 }
 
+// NewGithubUserClient creates a client for a user authenticated through the
+// OAuth web flow. Commits made through it are authored by that user.
+func NewGithubUserClient(ctx context.Context, ts oauth2.TokenSource, userLogin string, host GitHubHost) (*GitHubClient, error) {
+	if _, err := ts.Token(); err != nil {
+		return nil, fmt.Errorf("[NewGithubUserClient] error getting user token: %s", err.Error())
+	}
+
+	ctx, err := host.HTTPClientContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("[NewGithubUserClient] %s", err.Error())
+	}
+
+	client, err := host.newClient(oauth2.NewClient(ctx, ts))
+	if err != nil {
+		return nil, fmt.Errorf("[NewGithubUserClient] %s", err.Error())
+	}
+
+	return &GitHubClient{
+		Client:      client,
+		tokenSource: ts,
+		cloneHost:   host.GetCloneHost(),
+		rate:        &rateState{},
+		userLogin:   userLogin,
+	}, nil
+}
+
+// CloneURL embeds a token fetched from the client's token source, so short
+// lived installation tokens are refreshed before every clone.
+func (ghc *GitHubClient) CloneURL(repoOwner, repoName string) string {
+	cloneURL := &url.URL{Scheme: "https", Host: ghc.cloneHost, Path: fmt.Sprintf("/%s/%s.git", repoOwner, repoName)}
+	if ghc.tokenSource != nil {
+		token, err := ghc.tokenSource.Token()
+		if err != nil {
+			log.Println("[CloneURL] failed to get access token: ", err.Error())
+		} else {
+			cloneURL.User = url.UserPassword("x-access-token", token.AccessToken)
+		}
+	}
+
+	return cloneURL.String()
+}
+
+// ListRepositories lists the public repositories of repoOwner. For a user
+// client listing its own login, it lists every repository the user owns,
+// collaborates on or can access through an organization. An installation
+// client lists the repositories the installation was granted.
 func (ghc *GitHubClient) ListRepositories(ctx context.Context, repoOwner string) ([]*github.Repository, error) {
 	log.Printf("[ListRepositories] Fetching repositories: %s\n", repoOwner)
-	repos, resp, err := ghc.Repositories.List(ctx, repoOwner, nil)
+	if ghc.installation {
+		var repos []*github.Repository
+		opts := &github.ListOptions{PerPage: listPerPage}
+		for fetched := 1; ; fetched++ {
+			var installationRepos *github.ListRepositories
+			resp, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+				installationRepos, resp, err = ghc.Apps.ListRepos(ctx, opts)
+				return resp, err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("[ListRepositories] error fetching installation repositories: %w", err)
+			}
+			if resp != nil && resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("[ListRepositories] Resp status not OK: %s", resp.Status)
+			}
+
+			repos = append(repos, installationRepos.Repositories...)
+			if opts.Page = nextListPage(resp, fetched); opts.Page == 0 {
+				return repos, nil
+			}
+		}
+	}
+
+	var repos []*github.Repository
+	page := 1
+	for fetched := 1; ; fetched++ {
+		pageRepos, resp, err := ghc.repositoriesPage(ctx, repoOwner, page, listPerPage)
+		if err != nil {
+			return nil, err
+		}
+
+		repos = append(repos, pageRepos...)
+		if page = nextListPage(resp, fetched); page == 0 {
+			return repos, nil
+		}
+	}
+}
+
+// ListRepositoriesPage returns a single page of the repositories listed by
+// ListRepositories and their total count.
+func (ghc *GitHubClient) ListRepositoriesPage(ctx context.Context, repoOwner string, page, perPage int) ([]*github.Repository, ListPage, error) {
+	log.Printf("[ListRepositoriesPage] Fetching page %d of repositories: %s\n", page, repoOwner)
+	if ghc.installation {
+		var installationRepos *github.ListRepositories
+		resp, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+			installationRepos, resp, err = ghc.Apps.ListRepos(ctx, &github.ListOptions{Page: page, PerPage: perPage})
+			return resp, err
+		})
+		if err != nil {
+			return nil, ListPage{}, fmt.Errorf("[ListRepositoriesPage] error fetching installation repositories: %w", err)
+		}
+		if resp != nil && resp.StatusCode != http.StatusOK {
+			return nil, ListPage{}, fmt.Errorf("[ListRepositoriesPage] Resp status not OK: %s", resp.Status)
+		}
+
+		return installationRepos.Repositories, knownListPage(page, perPage, installationRepos.GetTotalCount()), nil
+	}
+
+	repos, resp, err := ghc.repositoriesPage(ctx, repoOwner, page, perPage)
 	if err != nil {
-		return nil, fmt.Errorf("[ListRepositories] error fetching repositories: %s", err.Error())
+		return nil, ListPage{}, err
+	}
+
+	total, err := pageTotal(resp, page, perPage, len(repos), func(page int) (int, *github.Response, error) {
+		repos, resp, err := ghc.repositoriesPage(ctx, repoOwner, page, perPage)
+		return len(repos), resp, err
+	})
+	if err != nil {
+		return nil, ListPage{}, err
+	}
+
+	return repos, knownListPage(page, perPage, total), nil
+}
+
+// repositoriesPage fetches a page of the repositories of repoOwner, or of
+// those the user can access when repoOwner is the login of a user client.
+func (ghc *GitHubClient) repositoriesPage(ctx context.Context, repoOwner string, page, perPage int) ([]*github.Repository, *github.Response, error) {
+	opts := &github.RepositoryListOptions{ListOptions: github.ListOptions{Page: page, PerPage: perPage}}
+	if ghc.userLogin != "" && strings.EqualFold(ghc.userLogin, repoOwner) {
+		repoOwner = ""
+		opts.Affiliation = "owner,collaborator,organization_member"
+	}
+
+	var repos []*github.Repository
+	resp, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+		repos, resp, err = ghc.Repositories.List(ctx, repoOwner, opts)
+		return resp, err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("[ListRepositories] error fetching repositories: %w", err)
 	}
 	if resp != nil && resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[ListRepositories] Resp status not OK: %s", resp.Status)
+		return nil, nil, fmt.Errorf("[ListRepositories] Resp status not OK: %s", resp.Status)
 	}
 
-	return repos, nil
+	return repos, resp, nil
 }
 
 func (ghc *GitHubClient) GetRepository(ctx context.Context, repoOwner, repoName string) (*github.Repository, error) {
 	log.Printf("[GetRepository] Fetching repository: %s/%s\n", repoOwner, repoName)
-	repo, resp, err := ghc.Repositories.Get(ctx, repoOwner, repoName)
+	var repo *github.Repository
+	resp, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+		repo, resp, err = ghc.Repositories.Get(ctx, repoOwner, repoName)
+		return resp, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("[GetRepository] error getting repository: %v", err)
+		return nil, fmt.Errorf("[GetRepository] error getting repository: %w", err)
 	}
 	if resp != nil && resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("[GetRepository] Resp status not OK: %s", resp.Status)
@@ -71,73 +304,241 @@ func (ghc *GitHubClient) GetRepository(ctx context.Context, repoOwner, repoName
 
 func (ghc *GitHubClient) ListRepositoryBranches(ctx context.Context, repoOwner, repoName string) ([]*github.Branch, error) {
 	log.Println("[ListRepositoryBranches] Fetching Repo Branches: ", repoName)
-	branches, r, err := ghc.Repositories.ListBranches(ctx, repoOwner, repoName, nil)
+	var branches []*github.Branch
+	page := 1
+	for fetched := 1; ; fetched++ {
+		pageBranches, r, err := ghc.branchesPage(ctx, repoOwner, repoName, page, listPerPage)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, pageBranches...)
+		if page = nextListPage(r, fetched); page == 0 {
+			return branches, nil
+		}
+	}
+}
+
+// ListRepositoryBranchesPage returns a single page of the branches of the
+// repository and their total count.
+func (ghc *GitHubClient) ListRepositoryBranchesPage(ctx context.Context, repoOwner, repoName string, page, perPage int) ([]*github.Branch, ListPage, error) {
+	log.Printf("[ListRepositoryBranchesPage] Fetching page %d of Repo Branches: %s\n", page, repoName)
+	branches, r, err := ghc.branchesPage(ctx, repoOwner, repoName, page, perPage)
 	if err != nil {
-		return nil, fmt.Errorf("[ListRepositoryBranches] error fetching branches %s: %s", repoName, err.Error())
+		return nil, ListPage{}, err
 	}
-	if r != nil && r.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[ListRepositoryBranches] Resp status not OK %s: %s", repoName, r.Status)
+
+	total, err := pageTotal(r, page, perPage, len(branches), func(page int) (int, *github.Response, error) {
+		branches, r, err := ghc.branchesPage(ctx, repoOwner, repoName, page, perPage)
+		return len(branches), r, err
+	})
+	if err != nil {
+		return nil, ListPage{}, err
+	}
+
+	return branches, knownListPage(page, perPage, total), nil
+}
+
+// branchesPage fetches a page of the branches of the repository, revalidating
+// a cached copy of it.
+func (ghc *GitHubClient) branchesPage(ctx context.Context, repoOwner, repoName string, page, perPage int) ([]*github.Branch, *github.Response, error) {
+	u := fmt.Sprintf("repos/%s/%s/branches?per_page=%d", url.PathEscape(repoOwner), url.PathEscape(repoName), perPage)
+	if page > 1 {
+		u += fmt.Sprintf("&page=%d", page)
+	}
+
+	var branches []*github.Branch
+	r, err := ghc.conditionalGet(ctx, u, &branches)
+	if err != nil {
+		return nil, nil, fmt.Errorf("[ListRepositoryBranches] error fetching branches %s: %w", repoName, err)
+	}
+	if r != nil && r.StatusCode != http.StatusOK && r.StatusCode != http.StatusNotModified {
+		return nil, nil, fmt.Errorf("[ListRepositoryBranches] Resp status not OK %s: %s", repoName, r.Status)
 	}
 
-	return branches, nil
+	return branches, r, nil
 }
 
 func (ghc *GitHubClient) GetRepositoryBranch(ctx context.Context, repoOwner, repoName, branchName string) (*github.Branch, error) {
 	log.Println("[GetRepositoryBranch] Fetching Branch: ", branchName)
-	branch, r, err := ghc.Repositories.GetBranch(ctx, repoOwner, repoName, branchName, false)
+	branch := &github.Branch{}
+	u := fmt.Sprintf("repos/%s/%s/branches/%s", url.PathEscape(repoOwner), url.PathEscape(repoName), url.PathEscape(branchName))
+	r, err := ghc.conditionalGet(ctx, u, branch)
 	if err != nil {
-		return nil, fmt.Errorf("[GetRepositoryBranch] error fetching branch %s: %s", branchName, err.Error())
+		return nil, fmt.Errorf("[GetRepositoryBranch] error fetching branch %s: %w", branchName, err)
 	}
-	if r != nil && r.StatusCode != http.StatusOK {
+	if r != nil && r.StatusCode != http.StatusOK && r.StatusCode != http.StatusNotModified {
 		return nil, fmt.Errorf("[GetRepositoryBranch] Resp status not OK %s: %s", branchName, r.Status)
 	}
 
 	return branch, nil
 }
 
+// GetBranchTree fetches the recursive tree of a branch. GitHub truncates large
+// recursive trees, in which case the tree is walked one directory at a time
+// instead. The result is only marked truncated if that walk is cut short too.
+// A branch name is first resolved to its head commit with a conditional
+// request, so the tree is cached by SHA either way, unless truncated.
 func (ghc *GitHubClient) GetBranchTree(ctx context.Context, repoOwner, repoName, branchSHA string) (*github.Tree, error) {
+	if !isObjectSHA(branchSHA) {
+		branch, err := ghc.GetRepositoryBranch(ctx, repoOwner, repoName, branchSHA)
+		var errResp *github.ErrorResponse
+		switch {
+		case err == nil:
+			if headSHA := branch.GetCommit().GetSHA(); isObjectSHA(headSHA) {
+				branchSHA = headSHA
+			}
+		case errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound:
+			// Not a branch, such as a tag, which is fetched uncached.
+			log.Println("[GetBranchTree] Not a branch, fetching tree by name: ", branchSHA)
+		default:
+			return nil, fmt.Errorf("[GetBranchTree] error resolving branch %s: %w", branchSHA, err)
+		}
+	}
+
+	cacheKey := ""
+	if isObjectSHA(branchSHA) {
+		cacheKey = ghc.cacheKey(repoOwner, repoName, "tree", branchSHA)
+		cached := &github.Tree{}
+		if objectCache.Get(cacheKey, cached) {
+			return cached, nil
+		}
+	}
+
+	tree, err := ghc.fetchBranchTree(ctx, repoOwner, repoName, branchSHA)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheKey != "" && !tree.GetTruncated() {
+		objectCache.Put(cacheKey, tree)
+	}
+
+	return tree, nil
+}
+
+func (ghc *GitHubClient) fetchBranchTree(ctx context.Context, repoOwner, repoName, branchSHA string) (*github.Tree, error) {
 	log.Println("[GetBranchTree] Fetching Branch Tree: ", branchSHA)
-	tree, r, err := ghc.Git.GetTree(ctx, repoOwner, repoName, branchSHA, true)
+	var tree *github.Tree
+	r, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+		tree, resp, err = ghc.Git.GetTree(ctx, repoOwner, repoName, branchSHA, true)
+		return resp, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s", err.Error())
+		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %w", err)
 	}
 	if r != nil && r.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("[GetBranchTree] Resp status not OK: %s", r.Status)
 	}
 
-	return tree, nil
+	if !tree.GetTruncated() {
+		return tree, nil
+	}
+
+	log.Println("[GetBranchTree] Recursive tree truncated, walking subtrees: ", branchSHA)
+	return ghc.walkTree(ctx, repoOwner, repoName, branchSHA)
+}
+
+// walkTree lists a tree breadth first with non-recursive requests, rewriting
+// entry paths to be relative to the root like a recursive tree.
+func (ghc *GitHubClient) walkTree(ctx context.Context, repoOwner, repoName, treeSHA string) (*github.Tree, error) {
+	type pendingTree struct {
+		sha    string
+		prefix string
+	}
+
+	result := &github.Tree{Truncated: github.Bool(false)}
+	pending := []pendingTree{{sha: treeSHA}}
+	for requests := 0; len(pending) > 0; requests++ {
+		if requests == maxTreeWalkRequests {
+			log.Printf("[walkTree] Stopped after %d subtrees, %d left unread\n", requests, len(pending))
+			result.Truncated = github.Bool(true)
+			break
+		}
+
+		next := pending[0]
+		pending = pending[1:]
+
+		var tree *github.Tree
+		r, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+			tree, resp, err = ghc.Git.GetTree(ctx, repoOwner, repoName, next.sha, false)
+			return resp, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[walkTree] error fetching tree %s: %w", next.prefix, err)
+		}
+		if r != nil && r.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("[walkTree] Resp status not OK %s: %s", next.prefix, r.Status)
+		}
+
+		if requests == 0 {
+			result.SHA = tree.SHA
+		}
+		if tree.GetTruncated() {
+			result.Truncated = github.Bool(true)
+		}
+
+		for _, entry := range tree.Entries {
+			if entry == nil || entry.Path == nil {
+				continue
+			}
+
+			path := next.prefix + entry.GetPath()
+			entry.Path = &path
+			result.Entries = append(result.Entries, entry)
+			if entry.GetType() == "tree" {
+				pending = append(pending, pendingTree{sha: entry.GetSHA(), prefix: path + "/"})
+			}
+		}
+	}
+
+	return result, nil
 }
 
 func (ghc *GitHubClient) GetBlob(ctx context.Context, repoOwner, repoName, blobSHA string) (*github.Blob, error) {
-	blob, r, err := ghc.Git.GetBlob(ctx, repoOwner, repoName, blobSHA)
+	cacheKey := ghc.cacheKey(repoOwner, repoName, "blob", blobSHA)
+	cached := &github.Blob{}
+	if objectCache.Get(cacheKey, cached) {
+		return cached, nil
+	}
+
+	var blob *github.Blob
+	r, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+		blob, resp, err = ghc.Git.GetBlob(ctx, repoOwner, repoName, blobSHA)
+		return resp, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s", err.Error())
+		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %w", err)
 	}
 	if r != nil && r.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("[GetBranchTree] Resp status not OK: %s", r.Status)
 	}
 
+	objectCache.Put(cacheKey, blob)
 	return blob, nil
 }
 
 func (ghc *GitHubClient) GetBlobsFromSHASlice(ctx context.Context, repoOwner, repoName string, blobSHAs []string) ([]*github.Blob, error) {
-	var blobs []*github.Blob
-	for _, sha := range blobSHAs {
-		blob, err := ghc.GetBlob(ctx, repoOwner, repoName, sha)
-		if err != nil {
-			return nil, fmt.Errorf("[GetBlobsFromSHASlice] error getting blob slice: %s", err.Error())
-		}
-
-		blobs = append(blobs, blob)
+	blobs, err := FetchBlobs(ctx, ghc, repoOwner, repoName, blobSHAs, FailFast)
+	if err != nil {
+		return nil, fmt.Errorf("[GetBlobsFromSHASlice] error getting blob slice: %w", err)
 	}
 
 	return blobs, nil
 }
 
 func (ghc *GitHubClient) GetReference(ctx context.Context, sourceOwner, sourceRepo, commitBranch, baseBranch string) (*github.Reference, error) {
-	if ref, _, err := ghc.Git.GetRef(ctx, sourceOwner, sourceRepo, "refs/heads/"+commitBranch); err == nil {
+	var ref *github.Reference
+	_, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+		ref, resp, err = ghc.Git.GetRef(ctx, sourceOwner, sourceRepo, "refs/heads/"+commitBranch)
+		return resp, err
+	})
+	if err == nil {
 		return ref, nil
 	}
+	if _, limited := IsRateLimitError(err); limited {
+		return nil, err
+	}
 
 	// We consider that an error means the branch has not been found and needs to
 	// be created.
@@ -150,76 +551,62 @@ func (ghc *GitHubClient) GetReference(ctx context.Context, sourceOwner, sourceRe
 	}
 
 	var baseRef *github.Reference
-	var err error
-	if baseRef, _, err = ghc.Git.GetRef(ctx, sourceOwner, sourceRepo, "refs/heads/"+baseBranch); err != nil {
+	if _, err = ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+		baseRef, resp, err = ghc.Git.GetRef(ctx, sourceOwner, sourceRepo, "refs/heads/"+baseBranch)
+		return resp, err
+	}); err != nil {
 		return nil, err
 	}
 	newRef := &github.Reference{Ref: github.String("refs/heads/" + commitBranch), Object: &github.GitObject{SHA: baseRef.Object.SHA}}
-	ref, _, err := ghc.Git.CreateRef(ctx, sourceOwner, sourceRepo, newRef)
+	ref, resp, err := ghc.Git.CreateRef(ctx, sourceOwner, sourceRepo, newRef)
+	ghc.rate.observe(resp)
 	return ref, err
 }
 
-// getTree generates the tree to commit based on the given files and the commit
-// of the ref you got in getRef.
-func (ghc *GitHubClient) GenerateCommitTree(ctx context.Context, ref *github.Reference, sourceOwner, sourceRepo, sourceFiles string) (*github.Tree, error) {
-	// Create a tree with what to commit.
-	entries := []*github.TreeEntry{}
-
-	// Load each file into the tree.
-	for _, fileArg := range strings.Split(sourceFiles, ",") {
-		file, content, err := getFileContent(fileArg)
-		if err != nil {
-			return nil, err
-		}
-		entries = append(entries, &github.TreeEntry{Path: github.String(file), Type: github.String("blob"), Content: github.String(string(content)), Mode: github.String("100644")})
+// GenerateCommitTree creates a tree on top of the commit of ref with the
+// changes applied.
+func (ghc *GitHubClient) GenerateCommitTree(ctx context.Context, ref *github.Reference, sourceOwner, sourceRepo string, changes []FileChange) (*github.Tree, error) {
+	entries := make([]*github.TreeEntry, 0, len(changes))
+	for _, change := range changes {
+		entries = append(entries, change.treeEntry())
 	}
 
-	tree, _, err := ghc.Git.CreateTree(ctx, sourceOwner, sourceRepo, *ref.Object.SHA, entries)
+	tree, resp, err := ghc.Git.CreateTree(ctx, sourceOwner, sourceRepo, *ref.Object.SHA, entries)
+	ghc.rate.observe(resp)
 	return tree, err
 }
 
-// getFileContent loads the local content of a file and return the target name
-// of the file in the target repository and its contents.
-func getFileContent(fileArg string) (targetName string, b []byte, err error) {
-	var localFile string
-	files := strings.Split(fileArg, ":")
-	switch {
-	case len(files) < 1:
-		return "", nil, errors.New("empty `-files` parameter")
-	case len(files) == 1:
-		localFile = files[0]
-		targetName = files[0]
-	default:
-		localFile = files[0]
-		targetName = files[1]
-	}
-
-	b, err = ioutil.ReadFile(localFile)
-	return targetName, b, err
-}
-
 // pushCommit creates the commit in the given reference using the given tree.
 func (ghc *GitHubClient) CreateCommit(ctx context.Context, ref *github.Reference, tree *github.Tree, sourceOwner, sourceRepo string) (*github.Commit, error) {
 	// Get the parent commit to attach the commit to.
-	parent, _, err := ghc.Repositories.GetCommit(ctx, sourceOwner, sourceRepo, *ref.Object.SHA, nil)
+	var parent *github.RepositoryCommit
+	_, err := ghc.withRetry(ctx, func() (resp *github.Response, err error) {
+		parent, resp, err = ghc.Repositories.GetCommit(ctx, sourceOwner, sourceRepo, *ref.Object.SHA, nil)
+		return resp, err
+	})
 	if err != nil {
 		return nil, err
 	}
 	// This is not always populated, but is needed.
 	parent.Commit.SHA = parent.SHA
 
-	// Create the commit using the tree.
-	date := time.Now()
-	author := &github.CommitAuthor{Date: &date, Name: &commitName, Email: &commitEmail}
-	commit := &github.Commit{Author: author, Message: &commitMessage, Tree: tree, Parents: []*github.Commit{parent.Commit}}
-	newCommit, _, err := ghc.Git.CreateCommit(ctx, sourceOwner, sourceRepo, commit)
+	// Create the commit using the tree. Without an explicit author GitHub
+	// attributes the commit to the logged in user.
+	commit := &github.Commit{Message: &commitMessage, Tree: tree, Parents: []*github.Commit{parent.Commit}}
+	if ghc.userLogin == "" {
+		date := time.Now()
+		commit.Author = &github.CommitAuthor{Date: &date, Name: &commitName, Email: &commitEmail}
+	}
+	newCommit, resp, err := ghc.Git.CreateCommit(ctx, sourceOwner, sourceRepo, commit)
+	ghc.rate.observe(resp)
 	if err != nil {
 		return nil, err
 	}
 
 	// Attach the commit to the master branch.
 	ref.Object.SHA = newCommit.SHA
-	_, _, err = ghc.Git.UpdateRef(ctx, sourceOwner, sourceRepo, ref, false)
+	_, resp, err = ghc.Git.UpdateRef(ctx, sourceOwner, sourceRepo, ref, false)
+	ghc.rate.observe(resp)
 	return newCommit, err
 }
 
@@ -249,7 +636,8 @@ func (ghc *GitHubClient) CreatePullRequest(ctx context.Context, prSubject, prRep
 		MaintainerCanModify: github.Bool(true),
 	}
 
-	pr, _, err := ghc.PullRequests.Create(ctx, prRepoOwner, prRepo, newPR)
+	pr, resp, err := ghc.PullRequests.Create(ctx, prRepoOwner, prRepo, newPR)
+	ghc.rate.observe(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,129 @@
+package types
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-github/v38/github"
+	"golang.org/x/oauth2"
+)
+
+const defaultGitHubCloneHost = "github.com"
+
+// GitHubHost describes where a GitHub instance serves its API and git
+// repositories. The zero value is github.com; GitHub Enterprise Server
+// instances set BaseURL to their `/api/v3/` endpoint.
+type GitHubHost struct {
+	BaseURL   string
+	UploadURL string
+	// CloneHost is the host used in git clone URLs. It defaults to the host of
+	// BaseURL, or github.com.
+	CloneHost string
+	// CABundle is a PEM file of extra certificate authorities trusted when
+	// talking to the host, for instances behind an internal CA.
+	CABundle string
+
+	// httpTransport trusts CABundle. It is built once by NewGitHubHost and
+	// shared by every client of the host, so connections are reused.
+	httpTransport http.RoundTripper
+}
+
+// NewGitHubHost returns host with the HTTP transport trusting its CA bundle
+// built, to be shared by every client made for the host.
+func NewGitHubHost(host GitHubHost) (GitHubHost, error) {
+	transport, err := newHostTransport(host.CABundle)
+	if err != nil {
+		return GitHubHost{}, err
+	}
+
+	host.httpTransport = transport
+	return host, nil
+}
+
+// GetCloneHost returns the host git repositories are cloned from.
+func (h GitHubHost) GetCloneHost() string {
+	if h.CloneHost != "" {
+		return h.CloneHost
+	}
+
+	if h.BaseURL != "" {
+		if baseURL, err := url.Parse(h.BaseURL); err == nil && baseURL.Host != "" {
+			return baseURL.Host
+		}
+	}
+
+	return defaultGitHubCloneHost
+}
+
+// WebURL is the root of the web UI, where the OAuth endpoints live.
+func (h GitHubHost) WebURL() string {
+	return "https://" + h.GetCloneHost()
+}
+
+// HTTPClientContext returns ctx carrying an HTTP client that trusts the CA
+// bundle of the host, for use with oauth2 which reads its transport from the
+// context.
+func (h GitHubHost) HTTPClientContext(ctx context.Context) (context.Context, error) {
+	transport, err := h.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport}), nil
+}
+
+func (h GitHubHost) newClient(httpClient *http.Client) (*github.Client, error) {
+	if h.BaseURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+
+	uploadURL := h.UploadURL
+	if uploadURL == "" {
+		uploadURL = h.BaseURL
+	}
+
+	client, err := github.NewEnterpriseClient(h.BaseURL, uploadURL, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL %s: %s", h.BaseURL, err.Error())
+	}
+
+	return client, nil
+}
+
+// transport returns the transport built by NewGitHubHost. A host that did not
+// go through it builds a new one on every call.
+func (h GitHubHost) transport() (http.RoundTripper, error) {
+	if h.httpTransport != nil {
+		return h.httpTransport, nil
+	}
+
+	return newHostTransport(h.CABundle)
+}
+
+func newHostTransport(caBundle string) (http.RoundTripper, error) {
+	if caBundle == "" {
+		return http.DefaultTransport, nil
+	}
+
+	caPEM, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle %s: %s", caBundle, err.Error())
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundle)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
+}
@@ -0,0 +1,175 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v38/github"
+)
+
+const (
+	maxRateLimitRetries = 3
+	rateLimitBaseDelay  = time.Second
+	// maxRateLimitWait is the longest a request waits for a primary rate limit
+	// to reset before giving up and returning the error.
+	maxRateLimitWait = time.Minute
+)
+
+// RateLimitReporter is implemented by providers that track their GitHub API
+// quota.
+type RateLimitReporter interface {
+	// LastRate returns the quota reported by the most recent API response.
+	LastRate() (github.Rate, bool)
+	// RateLimit fetches the current quota, which does not count against it.
+	// GitHub App quotas are per installation, so repoOwner selects one.
+	RateLimit(ctx context.Context, repoOwner string) (*github.Rate, error)
+}
+
+// IsRateLimitError reports whether err was caused by a primary or secondary
+// rate limit, and how long to wait before trying again.
+func IsRateLimitError(err error) (time.Duration, bool) {
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		return abuseErr.GetRetryAfter(), true
+	}
+
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		wait := time.Until(rateErr.Rate.Reset.Time)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	// Newer GitHub versions document secondary limits under a URL go-github
+	// does not recognize yet, so they arrive as a plain error response.
+	var respErr *github.ErrorResponse
+	if errors.As(err, &respErr) && respErr.Response != nil && isSecondaryRateLimit(respErr) {
+		retryAfter, _ := strconv.Atoi(respErr.Response.Header.Get("Retry-After"))
+		return time.Duration(retryAfter) * time.Second, true
+	}
+
+	return 0, false
+}
+
+func isSecondaryRateLimit(respErr *github.ErrorResponse) bool {
+	switch respErr.Response.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return respErr.Response.Header.Get("Retry-After") != "" ||
+			strings.Contains(respErr.DocumentationURL, "secondary-rate-limits") ||
+			strings.Contains(strings.ToLower(respErr.Message), "secondary rate limit")
+	}
+
+	return false
+}
+
+type rateState struct {
+	mu    sync.Mutex
+	rate  github.Rate
+	known bool
+}
+
+func (rs *rateState) observe(resp *github.Response) {
+	if resp == nil || resp.Rate.Limit == 0 {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.rate = resp.Rate
+	rs.known = true
+}
+
+func (rs *rateState) last() (github.Rate, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.rate, rs.known
+}
+
+// retryDelay returns how long to wait before retrying a call that failed with
+// err, or false if it should not be retried. Secondary limits are retried after
+// Retry-After, or an exponential backoff when it is missing, primary limits
+// only if they reset soon.
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	if attempt >= maxRateLimitRetries {
+		return 0, false
+	}
+
+	wait, ok := IsRateLimitError(err)
+	if !ok || wait > maxRateLimitWait {
+		return 0, false
+	}
+
+	backoff := rateLimitBaseDelay << attempt
+	if wait < backoff {
+		wait = backoff
+	}
+
+	return wait + time.Duration(rand.Int63n(int64(backoff))), true
+}
+
+// withRetry runs an idempotent GitHub call, retrying it while it is rate
+// limited. Only use it for reads, a retried write may be applied twice.
+func (ghc *GitHubClient) withRetry(ctx context.Context, call func() (*github.Response, error)) (*github.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := call()
+		ghc.rate.observe(resp)
+		if err == nil {
+			return resp, nil
+		}
+
+		delay, ok := retryDelay(err, attempt)
+		if !ok {
+			return resp, err
+		}
+
+		log.Printf("[withRetry] Rate limited, retrying in %s: %s\n", delay, err.Error())
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (ghc *GitHubClient) LastRate() (github.Rate, bool) {
+	return ghc.rate.last()
+}
+
+func (ghc *GitHubClient) RateLimit(ctx context.Context, repoOwner string) (*github.Rate, error) {
+	limits, resp, err := ghc.RateLimits(ctx)
+	ghc.rate.observe(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return limits.GetCore(), nil
+}
+
+// LastRate returns the quota of the most recently used installation.
+func (gap *GitHubAppProvider) LastRate() (github.Rate, bool) {
+	return gap.rate.last()
+}
+
+func (gap *GitHubAppProvider) RateLimit(ctx context.Context, repoOwner string) (*github.Rate, error) {
+	if repoOwner == "" {
+		return nil, errors.New("[RateLimit] repoOwner is required, GitHub App quotas are per installation")
+	}
+
+	client, err := gap.ClientForOwner(ctx, repoOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.RateLimit(ctx, repoOwner)
+}
@@ -0,0 +1,447 @@
+package types
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v38/github"
+	"github.com/xanzy/go-gitlab"
+)
+
+const defaultGitLabURL = "https://gitlab.com"
+
+// GitLabClient implements SourceControlProvider against the GitLab REST API.
+// Projects are addressed by their full namespace path, so a repoOwner may be a
+// user, a group or a nested "group/subgroup". Results are mapped onto the
+// go-github types the route handlers already work with.
+type GitLabClient struct {
+	*gitlab.Client
+	accessToken string
+	webURL      *url.URL
+}
+
+func NewGitlabClient(accessToken, baseURL string) (*GitLabClient, error) {
+	if baseURL == "" {
+		baseURL = defaultGitLabURL
+	}
+
+	webURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("[NewGitlabClient] invalid GitLab URL %s: %s", baseURL, err.Error())
+	}
+
+	log.Println("Creating GitLab client...")
+	RedactSecret(accessToken)
+	client, err := gitlab.NewClient(accessToken, gitlab.WithBaseURL(baseURL))
+	if err != nil {
+		return nil, fmt.Errorf("[NewGitlabClient] error creating client: %s", err.Error())
+	}
+
+	return &GitLabClient{
+		Client:      client,
+		accessToken: accessToken,
+		webURL:      webURL,
+	}, nil
+}
+
+// ListRepositories lists the projects of a group, falling back to the projects
+// of a user when no group with that path exists. Pages are followed up to the
+// list page limit.
+func (glc *GitLabClient) ListRepositories(ctx context.Context, repoOwner string) ([]*github.Repository, error) {
+	log.Printf("[ListRepositories] Fetching projects: %s\n", repoOwner)
+	var repos []*github.Repository
+	userProjects := false
+	listOptions := gitlab.ListOptions{PerPage: listPerPage}
+	for fetched := 1; ; fetched++ {
+		page, resp, err := glc.projectsPage(ctx, repoOwner, listOptions, &userProjects)
+		if err != nil {
+			return nil, fmt.Errorf("[ListRepositories] error fetching projects: %s", err.Error())
+		}
+
+		repos = append(repos, page...)
+		if listOptions.Page = nextGitLabPage(resp, fetched); listOptions.Page == 0 {
+			return repos, nil
+		}
+	}
+}
+
+// ListRepositoriesPage returns a single page of the projects listed by
+// ListRepositories and their total count.
+func (glc *GitLabClient) ListRepositoriesPage(ctx context.Context, repoOwner string, page, perPage int) ([]*github.Repository, ListPage, error) {
+	log.Printf("[ListRepositoriesPage] Fetching page %d of projects: %s\n", page, repoOwner)
+	userProjects := false
+	repos, resp, err := glc.projectsPage(ctx, repoOwner, gitlab.ListOptions{Page: page, PerPage: perPage}, &userProjects)
+	if err != nil {
+		return nil, ListPage{}, fmt.Errorf("[ListRepositoriesPage] error fetching projects: %s", err.Error())
+	}
+
+	return repos, gitlabListPage(resp, page, perPage), nil
+}
+
+// projectsPage fetches a page of the projects of the group repoOwner, or of
+// the user repoOwner once userProjects is set. userProjects is set when no
+// group is found.
+func (glc *GitLabClient) projectsPage(ctx context.Context, repoOwner string, listOptions gitlab.ListOptions, userProjects *bool) ([]*github.Repository, *gitlab.Response, error) {
+	var projects []*gitlab.Project
+	var resp *gitlab.Response
+	var err error
+	if !*userProjects {
+		projects, resp, err = glc.Groups.ListGroupProjects(repoOwner, &gitlab.ListGroupProjectsOptions{ListOptions: listOptions}, gitlab.WithContext(ctx))
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			*userProjects = true
+		}
+	}
+	if *userProjects {
+		projects, resp, err = glc.Projects.ListUserProjects(repoOwner, &gitlab.ListProjectsOptions{ListOptions: listOptions}, gitlab.WithContext(ctx))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repos := make([]*github.Repository, 0, len(projects))
+	for _, project := range projects {
+		repos = append(repos, &github.Repository{
+			ID:            github.Int64(int64(project.ID)),
+			Name:          github.String(project.Path),
+			FullName:      github.String(project.PathWithNamespace),
+			Description:   github.String(project.Description),
+			DefaultBranch: github.String(project.DefaultBranch),
+			HTMLURL:       github.String(project.WebURL),
+			Owner:         &github.User{Login: github.String(repoOwner)},
+		})
+	}
+
+	return repos, resp, nil
+}
+
+func (glc *GitLabClient) ListRepositoryBranches(ctx context.Context, repoOwner, repoName string) ([]*github.Branch, error) {
+	log.Println("[ListRepositoryBranches] Fetching Project Branches: ", repoName)
+	var branches []*gitlab.Branch
+	opts := &gitlab.ListBranchesOptions{ListOptions: gitlab.ListOptions{PerPage: listPerPage}}
+	for fetched := 1; ; fetched++ {
+		page, resp, err := glc.Branches.ListBranches(gitlabProjectID(repoOwner, repoName), opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("[ListRepositoryBranches] error fetching branches %s: %s", repoName, err.Error())
+		}
+
+		branches = append(branches, page...)
+		if opts.Page = nextGitLabPage(resp, fetched); opts.Page == 0 {
+			break
+		}
+	}
+
+	ghBranches := make([]*github.Branch, 0, len(branches))
+	for _, branch := range branches {
+		ghBranches = append(ghBranches, gitlabBranch(branch))
+	}
+
+	return ghBranches, nil
+}
+
+// ListRepositoryBranchesPage returns a single page of the branches of the
+// project and their total count.
+func (glc *GitLabClient) ListRepositoryBranchesPage(ctx context.Context, repoOwner, repoName string, page, perPage int) ([]*github.Branch, ListPage, error) {
+	log.Printf("[ListRepositoryBranchesPage] Fetching page %d of Project Branches: %s\n", page, repoName)
+	opts := &gitlab.ListBranchesOptions{ListOptions: gitlab.ListOptions{Page: page, PerPage: perPage}}
+	branches, resp, err := glc.Branches.ListBranches(gitlabProjectID(repoOwner, repoName), opts, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, ListPage{}, fmt.Errorf("[ListRepositoryBranchesPage] error fetching branches %s: %s", repoName, err.Error())
+	}
+
+	ghBranches := make([]*github.Branch, 0, len(branches))
+	for _, branch := range branches {
+		ghBranches = append(ghBranches, gitlabBranch(branch))
+	}
+
+	return ghBranches, gitlabListPage(resp, page, perPage), nil
+}
+
+func (glc *GitLabClient) GetRepositoryBranch(ctx context.Context, repoOwner, repoName, branchName string) (*github.Branch, error) {
+	log.Println("[GetRepositoryBranch] Fetching Branch: ", branchName)
+	branch, _, err := glc.Branches.GetBranch(gitlabProjectID(repoOwner, repoName), branchName, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("[GetRepositoryBranch] error fetching branch %s: %s", branchName, err.Error())
+	}
+
+	return gitlabBranch(branch), nil
+}
+
+// GetBranchTree walks every page of the recursive repository tree at the given
+// branch or commit. GitLab does not expose the root tree SHA, so the returned
+// tree is identified by the ref it was listed at.
+func (glc *GitLabClient) GetBranchTree(ctx context.Context, repoOwner, repoName, branchSHA string) (*github.Tree, error) {
+	log.Println("[GetBranchTree] Fetching Branch Tree: ", branchSHA)
+	opts := &gitlab.ListTreeOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Ref:         gitlab.String(branchSHA),
+		Recursive:   gitlab.Bool(true),
+	}
+
+	entries := make([]*github.TreeEntry, 0)
+	for {
+		nodes, resp, err := glc.Repositories.ListTree(gitlabProjectID(repoOwner, repoName), opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s", err.Error())
+		}
+
+		for _, node := range nodes {
+			entries = append(entries, &github.TreeEntry{
+				SHA:  github.String(node.ID),
+				Path: github.String(node.Path),
+				Type: github.String(node.Type),
+				Mode: github.String(node.Mode),
+			})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return &github.Tree{
+		SHA:       github.String(branchSHA),
+		Entries:   entries,
+		Truncated: github.Bool(false),
+	}, nil
+}
+
+// GetBlob returns the blob base64 encoded, matching the GitHub blob API.
+func (glc *GitLabClient) GetBlob(ctx context.Context, repoOwner, repoName, blobSHA string) (*github.Blob, error) {
+	contents, _, err := glc.Repositories.RawBlobContent(gitlabProjectID(repoOwner, repoName), blobSHA, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("[GetBlob] error fetching blob: %s", err.Error())
+	}
+
+	return &github.Blob{
+		SHA:      github.String(blobSHA),
+		Content:  github.String(base64.StdEncoding.EncodeToString(contents)),
+		Encoding: github.String("base64"),
+		Size:     github.Int(len(contents)),
+	}, nil
+}
+
+func (glc *GitLabClient) GetReference(ctx context.Context, sourceOwner, sourceRepo, commitBranch, baseBranch string) (*github.Reference, error) {
+	pid := gitlabProjectID(sourceOwner, sourceRepo)
+	if branch, _, err := glc.Branches.GetBranch(pid, commitBranch, gitlab.WithContext(ctx)); err == nil {
+		return gitlabReference(branch), nil
+	}
+
+	// We consider that an error means the branch has not been found and needs to
+	// be created.
+	if commitBranch == baseBranch {
+		return nil, fmt.Errorf("The commit branch does not exist but `-base-branch` is the same as `-commit-branch`")
+	}
+
+	if baseBranch == "" {
+		return nil, fmt.Errorf("The `-base-branch` should not be set to an empty string when the branch specified by `-commit-branch` does not exists")
+	}
+
+	branch, _, err := glc.Branches.CreateBranch(pid, &gitlab.CreateBranchOptions{
+		Branch: gitlab.String(commitBranch),
+		Ref:    gitlab.String(baseBranch),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("[GetReference] error creating branch %s: %s", commitBranch, err.Error())
+	}
+
+	return gitlabReference(branch), nil
+}
+
+// GenerateCommitTree turns the changes into tree entries. GitLab has no tree
+// API, so nothing is created remotely: the entries are only staged for
+// CreateCommit, which sends them as commit actions.
+func (glc *GitLabClient) GenerateCommitTree(ctx context.Context, ref *github.Reference, sourceOwner, sourceRepo string, changes []FileChange) (*github.Tree, error) {
+	entries := make([]*github.TreeEntry, 0, len(changes))
+	for _, change := range changes {
+		entries = append(entries, change.treeEntry())
+	}
+
+	return &github.Tree{
+		SHA:     ref.Object.SHA,
+		Entries: entries,
+	}, nil
+}
+
+// CreateCommit commits the staged tree entries to the branch of ref, creating
+// files that do not exist yet and updating the ones that do.
+func (glc *GitLabClient) CreateCommit(ctx context.Context, ref *github.Reference, tree *github.Tree, sourceOwner, sourceRepo string) (*github.Commit, error) {
+	pid := gitlabProjectID(sourceOwner, sourceRepo)
+	branch := strings.TrimPrefix(ref.GetRef(), "refs/heads/")
+
+	actions := make([]*gitlab.CommitActionOptions, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		// Entries without content are deletions, see FileChange.treeEntry.
+		if entry.Content == nil {
+			actions = append(actions, &gitlab.CommitActionOptions{
+				Action:   gitlab.FileAction(gitlab.FileDelete),
+				FilePath: gitlab.String(entry.GetPath()),
+			})
+			continue
+		}
+
+		action := gitlab.FileCreate
+		_, resp, err := glc.RepositoryFiles.GetFileMetaData(pid, entry.GetPath(), &gitlab.GetFileMetaDataOptions{Ref: gitlab.String(branch)}, gitlab.WithContext(ctx))
+		if err == nil {
+			action = gitlab.FileUpdate
+		} else if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return nil, fmt.Errorf("[CreateCommit] error checking file %s: %s", entry.GetPath(), err.Error())
+		}
+
+		actions = append(actions, &gitlab.CommitActionOptions{
+			Action:          gitlab.FileAction(action),
+			FilePath:        gitlab.String(entry.GetPath()),
+			Content:         gitlab.String(entry.GetContent()),
+			ExecuteFilemode: gitlab.Bool(entry.GetMode() == FileModeExecutable),
+		})
+	}
+
+	commit, _, err := glc.Commits.CreateCommit(pid, &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(branch),
+		CommitMessage: gitlab.String(commitMessage),
+		AuthorName:    gitlab.String(commitName),
+		AuthorEmail:   gitlab.String(commitEmail),
+		Actions:       actions,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("[CreateCommit] error creating commit: %s", err.Error())
+	}
+
+	ref.Object.SHA = github.String(commit.ID)
+
+	date := time.Now()
+	if commit.AuthoredDate != nil {
+		date = *commit.AuthoredDate
+	}
+	return &github.Commit{
+		SHA:     github.String(commit.ID),
+		Message: github.String(commit.Message),
+		Tree:    tree,
+		Author:  &github.CommitAuthor{Date: &date, Name: github.String(commit.AuthorName), Email: github.String(commit.AuthorEmail)},
+		HTMLURL: github.String(commit.WebURL),
+	}, nil
+}
+
+// CreatePullRequest opens a merge request. When prRepoOwner differs from
+// sourceOwner the merge request targets that project from the source fork.
+func (glc *GitLabClient) CreatePullRequest(ctx context.Context, prSubject, prRepoOwner, prRepo, prBranch, prDescription, sourceOwner, sourceRepo, commitBranch string) (*github.PullRequest, error) {
+	if prSubject == "" {
+		return nil, errors.New("missing `-pr-title` flag; skipping PR creation")
+	}
+
+	if prRepoOwner == "" {
+		prRepoOwner = sourceOwner
+	}
+
+	if prRepo == "" {
+		prRepo = sourceRepo
+	}
+
+	opts := &gitlab.CreateMergeRequestOptions{
+		Title:        gitlab.String(prSubject),
+		Description:  gitlab.String(prDescription),
+		SourceBranch: gitlab.String(commitBranch),
+		TargetBranch: gitlab.String(prBranch),
+	}
+
+	if prRepoOwner != sourceOwner || prRepo != sourceRepo {
+		target, _, err := glc.Projects.GetProject(gitlabProjectID(prRepoOwner, prRepo), &gitlab.GetProjectOptions{}, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("[CreatePullRequest] error fetching target project: %s", err.Error())
+		}
+		opts.TargetProjectID = gitlab.Int(target.ID)
+	}
+
+	log.Printf("Title: %s | Source: %s | Target: %s | Body: %s", prSubject, commitBranch, prBranch, prDescription)
+
+	mr, _, err := glc.MergeRequests.CreateMergeRequest(gitlabProjectID(sourceOwner, sourceRepo), opts, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("MR created: %s\n", mr.WebURL)
+	return &github.PullRequest{
+		ID:      github.Int64(int64(mr.ID)),
+		Number:  github.Int(mr.IID),
+		State:   github.String(mr.State),
+		Title:   github.String(mr.Title),
+		Body:    github.String(mr.Description),
+		HTMLURL: github.String(mr.WebURL),
+		Head:    &github.PullRequestBranch{Ref: github.String(mr.SourceBranch)},
+		Base:    &github.PullRequestBranch{Ref: github.String(mr.TargetBranch)},
+	}, nil
+}
+
+func (glc *GitLabClient) CloneURL(repoOwner, repoName string) string {
+	cloneURL := *glc.webURL
+	cloneURL.Path = strings.TrimSuffix(cloneURL.Path, "/") + fmt.Sprintf("/%s/%s.git", repoOwner, repoName)
+	if glc.accessToken != "" {
+		cloneURL.User = url.UserPassword("oauth2", glc.accessToken)
+	}
+
+	return cloneURL.String()
+}
+
+func gitlabProjectID(repoOwner, repoName string) string {
+	return repoOwner + "/" + repoName
+}
+
+func gitlabBranch(branch *gitlab.Branch) *github.Branch {
+	ghBranch := &github.Branch{
+		Name:      github.String(branch.Name),
+		Protected: github.Bool(branch.Protected),
+	}
+	if branch.Commit != nil {
+		ghBranch.Commit = &github.RepositoryCommit{SHA: github.String(branch.Commit.ID)}
+	}
+
+	return ghBranch
+}
+
+func gitlabReference(branch *gitlab.Branch) *github.Reference {
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + branch.Name),
+		Object: &github.GitObject{Type: github.String("commit")},
+	}
+	if branch.Commit != nil {
+		ref.Object.SHA = github.String(branch.Commit.ID)
+	}
+
+	return ref
+}
+
+// gitlabListPage describes the page page of a list answered with resp. The
+// total comes from the X-Total header, which GitLab leaves out for large
+// lists; it is then unknown.
+func gitlabListPage(resp *gitlab.Response, page, perPage int) ListPage {
+	if resp == nil {
+		return ListPage{}
+	}
+	if resp.TotalItems > 0 {
+		return knownListPage(page, perPage, resp.TotalItems)
+	}
+
+	return ListPage{HasMore: resp.NextPage != 0}
+}
+
+// nextGitLabPage returns the page to fetch after resp, or zero once the last
+// page or the page limit has been reached, like nextListPage for GitHub.
+func nextGitLabPage(resp *gitlab.Response, fetched int) int {
+	if resp == nil || resp.NextPage == 0 {
+		return 0
+	}
+
+	if maxListPages > 0 && fetched >= maxListPages {
+		log.Printf("[nextGitLabPage] Page limit of %d reached, results are truncated\n", maxListPages)
+		return 0
+	}
+
+	return resp.NextPage
+}
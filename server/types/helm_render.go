@@ -0,0 +1,90 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// valuesFilePattern matches the names of Helm values files, such as
+	// values.yaml, values-prod.yaml or values.staging.yml.
+	valuesFilePattern = regexp.MustCompile(`^values([.-][A-Za-z0-9_.-]+)?\.ya?ml$`)
+	// releaseNamePattern and namespacePattern are the rules Helm and
+	// Kubernetes apply to release names and namespaces.
+	releaseNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	namespacePattern   = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+const (
+	maxReleaseNameLength = 53
+	maxNamespaceLength   = 63
+)
+
+// HelmRenderOptions are passed on to helm template when rendering a chart.
+type HelmRenderOptions struct {
+	// ValuesFiles are paths relative to the root of the repository, applied
+	// in order.
+	ValuesFiles []string
+	// SetValues are --set overrides in the form key=value.
+	SetValues []string
+	// ReleaseName defaults to the name of the repository.
+	ReleaseName string
+	Namespace   string
+}
+
+// IsValuesFile reports whether the file at filePath is named like a Helm
+// values file.
+func IsValuesFile(filePath string) bool {
+	return valuesFilePattern.MatchString(path.Base(filePath))
+}
+
+// CleanHelmRenderOptions validates options and returns them with the paths of
+// the values files cleaned.
+func CleanHelmRenderOptions(options HelmRenderOptions) (HelmRenderOptions, error) {
+	cleaned := HelmRenderOptions{
+		ReleaseName: options.ReleaseName,
+		Namespace:   options.Namespace,
+	}
+
+	for _, valuesFile := range options.ValuesFiles {
+		filePath, err := CleanRepoPath(valuesFile)
+		if err != nil {
+			return HelmRenderOptions{}, err
+		}
+
+		ext := path.Ext(filePath)
+		if ext != ".yaml" && ext != ".yml" {
+			return HelmRenderOptions{}, fmt.Errorf("values file %s is not a YAML file", filePath)
+		}
+		cleaned.ValuesFiles = append(cleaned.ValuesFiles, filePath)
+	}
+
+	for _, setValue := range options.SetValues {
+		if strings.ContainsAny(setValue, "\r\n\x00") || !strings.Contains(setValue, "=") || strings.HasPrefix(setValue, "=") {
+			return HelmRenderOptions{}, fmt.Errorf("invalid value override %q, expected key=value", setValue)
+		}
+		cleaned.SetValues = append(cleaned.SetValues, setValue)
+	}
+
+	if cleaned.ReleaseName != "" && (len(cleaned.ReleaseName) > maxReleaseNameLength || !releaseNamePattern.MatchString(cleaned.ReleaseName)) {
+		return HelmRenderOptions{}, fmt.Errorf("invalid release name %q", cleaned.ReleaseName)
+	}
+
+	if cleaned.Namespace != "" && (len(cleaned.Namespace) > maxNamespaceLength || !namespacePattern.MatchString(cleaned.Namespace)) {
+		return HelmRenderOptions{}, fmt.Errorf("invalid namespace %q", cleaned.Namespace)
+	}
+
+	return cleaned, nil
+}
+
+// cacheKey identifies the manifest rendered from the chart at chartDir with
+// options.
+func (options HelmRenderOptions) cacheKey(chartDir string) string {
+	parts := []string{chartDir, options.ReleaseName, options.Namespace}
+	parts = append(parts, options.ValuesFiles...)
+	parts = append(parts, "")
+	parts = append(parts, options.SetValues...)
+	return strings.Join(parts, "\x00")
+}
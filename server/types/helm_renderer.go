@@ -0,0 +1,311 @@
+package types
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+)
+
+// Codes of the HelmError kinds, returned to API clients in APIError.
+const (
+	ErrCodeChartRenderFailed  = "chart_render_failed"
+	ErrCodeChartRenderTimeout = "chart_render_timeout"
+	ErrCodeChartTooLarge      = "chart_too_large"
+)
+
+// defaultNamespace is the namespace helm template renders into when none is
+// given.
+const defaultNamespace = "default"
+
+// templateErrorPatterns locate the failing template in the errors of the Helm
+// template engine and of the YAML parser run on its output.
+var templateErrorPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`template: ([^:\s]+):(\d+)`),
+	regexp.MustCompile(`parse error at \(([^:\s]+):(\d+)\)`),
+	regexp.MustCompile(`YAML parse error on ([^:\s]+):.*?line (\d+)`),
+}
+
+// HelmError reports a chart that could not be rendered. File and Line locate
+// the failing template, relative to the chart, when Helm reports it.
+type HelmError struct {
+	Chart string
+	File  string
+	Line  int
+	Code  string
+	Err   error
+}
+
+func (e *HelmError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("helm render %s: %s:%d: %s", e.Chart, e.File, e.Line, e.Err.Error())
+	}
+
+	return fmt.Sprintf("helm render %s: %s", e.Chart, e.Err.Error())
+}
+
+func (e *HelmError) Unwrap() error {
+	return e.Err
+}
+
+// newTemplateError wraps err, filling in the template it failed in.
+func newTemplateError(chartName string, err error) *HelmError {
+	helmErr := &HelmError{Chart: chartName, Code: ErrCodeChartRenderFailed, Err: err}
+	for _, pattern := range templateErrorPatterns {
+		if match := pattern.FindStringSubmatch(err.Error()); match != nil {
+			helmErr.File = match[1]
+			helmErr.Line, _ = strconv.Atoi(match[2])
+			break
+		}
+	}
+
+	return helmErr
+}
+
+// helmRenderer renders charts in-process with the Helm SDK, the way helm
+// template does.
+type helmRenderer struct {
+	settings *cli.EnvSettings
+	// timeout bounds a render, including building the dependencies of the
+	// chart, zero means no limit.
+	timeout time.Duration
+	// maxBytes caps the size of a loaded chart, with its dependencies, and of
+	// the rendered manifest, zero means no cap.
+	maxBytes int64
+}
+
+// render renders the chart in chartPath. The returned channel is closed once
+// the render stopped using chartPath: a render that times out is abandoned at
+// its next step, but the template engine cannot be interrupted, so the caller
+// must keep the checkout locked until then.
+func (hr *helmRenderer) render(chartPath string, valuesPaths []string, options HelmRenderOptions) (string, <-chan struct{}, error) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if hr.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, hr.timeout)
+	}
+
+	type result struct {
+		manifest string
+		err      error
+	}
+	done := make(chan result, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer cancel()
+		manifest, err := hr.renderChart(ctx, chartPath, valuesPaths, options)
+		done <- result{manifest: manifest, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.manifest, stopped, res.err
+	case <-ctx.Done():
+		return "", stopped, &HelmError{Chart: filepath.Base(chartPath), Code: ErrCodeChartRenderTimeout, Err: fmt.Errorf("render did not finish within %s", hr.timeout)}
+	}
+}
+
+func (hr *helmRenderer) renderChart(ctx context.Context, chartPath string, valuesPaths []string, options HelmRenderOptions) (string, error) {
+	chrt, err := hr.loadChart(ctx, chartPath)
+	if err != nil {
+		return "", err
+	}
+	chartName := chrt.Name()
+
+	vals, err := (&values.Options{ValueFiles: valuesPaths, Values: options.SetValues}).MergeValues(hr.getters(ctx))
+	if err != nil {
+		return "", &HelmError{Chart: chartName, Code: ErrCodeChartRenderFailed, Err: err}
+	}
+
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	install := action.NewInstall(&action.Configuration{Log: func(format string, v ...interface{}) {
+		log.Printf("[renderChart] "+format, v...)
+	}})
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	install.ReleaseName = options.ReleaseName
+	install.Namespace = namespace
+
+	if err := ctx.Err(); err != nil {
+		return "", &HelmError{Chart: chartName, Code: ErrCodeChartRenderTimeout, Err: err}
+	}
+
+	rel, err := install.RunWithContext(ctx, chrt, vals)
+	if err != nil {
+		return "", newTemplateError(chartName, err)
+	}
+
+	var manifest strings.Builder
+	manifest.WriteString(strings.TrimSpace(rel.Manifest))
+	manifest.WriteString("\n")
+	for _, hook := range rel.Hooks {
+		fmt.Fprintf(&manifest, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
+
+	if hr.maxBytes > 0 && int64(manifest.Len()) > hr.maxBytes {
+		return "", &HelmError{Chart: chartName, Code: ErrCodeChartTooLarge, Err: fmt.Errorf("rendered manifest is larger than %d bytes", hr.maxBytes)}
+	}
+
+	return manifest.String(), nil
+}
+
+// getters returns the getters of the Helm settings, with the downloads they
+// make bounded by the deadline of ctx.
+func (hr *helmRenderer) getters(ctx context.Context) getter.Providers {
+	providers := getter.All(hr.settings)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return providers
+	}
+
+	for i := range providers {
+		newGetter := providers[i].New
+		providers[i].New = func(options ...getter.Option) (getter.Getter, error) {
+			return newGetter(append(options, getter.WithTimeout(time.Until(deadline)))...)
+		}
+	}
+
+	return providers
+}
+
+// loadChart loads the chart in chartPath, building its dependencies first if
+// some of them are missing from its charts/ directory. The size of the chart
+// is checked on disk before it is loaded into memory.
+func (hr *helmRenderer) loadChart(ctx context.Context, chartPath string) (*chart.Chart, error) {
+	if err := hr.checkChartSize(chartPath); err != nil {
+		return nil, err
+	}
+
+	chrt, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, &HelmError{Chart: filepath.Base(chartPath), Code: ErrCodeChartRenderFailed, Err: err}
+	}
+
+	if dependencies := chrt.Metadata.Dependencies; len(dependencies) > 0 && action.CheckDependencies(chrt, dependencies) != nil {
+		log.Println("[loadChart] Building dependencies of: ", chrt.Name())
+		manager := &downloader.Manager{
+			Out:              ioutil.Discard,
+			ChartPath:        chartPath,
+			Getters:          hr.getters(ctx),
+			RepositoryConfig: hr.settings.RepositoryConfig,
+			RepositoryCache:  hr.settings.RepositoryCache,
+		}
+		if err := manager.Build(); err != nil {
+			return nil, &HelmError{Chart: chrt.Name(), Code: ErrCodeChartRenderFailed, Err: fmt.Errorf("building dependencies: %w", err)}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, &HelmError{Chart: chrt.Name(), Code: ErrCodeChartRenderTimeout, Err: err}
+		}
+
+		if err := hr.checkChartSize(chartPath); err != nil {
+			return nil, err
+		}
+
+		if chrt, err = loader.Load(chartPath); err != nil {
+			return nil, &HelmError{Chart: filepath.Base(chartPath), Code: ErrCodeChartRenderFailed, Err: err}
+		}
+	}
+
+	return chrt, nil
+}
+
+// checkChartSize fails if the files of the chart in chartPath, with packaged
+// dependencies counted unpacked as they are loaded into memory, are over the
+// size limit. Counting stops as soon as the limit is passed.
+func (hr *helmRenderer) checkChartSize(chartPath string) error {
+	if hr.maxBytes <= 0 {
+		return nil
+	}
+
+	tooLarge := &HelmError{Chart: filepath.Base(chartPath), Code: ErrCodeChartTooLarge, Err: fmt.Errorf("chart and its dependencies are more than %d bytes", hr.maxBytes)}
+
+	var size int64
+	err := filepath.Walk(chartPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if strings.HasSuffix(info.Name(), ".tgz") || strings.HasSuffix(info.Name(), ".tar.gz") {
+			archiveSize, err := archiveSize(filePath, hr.maxBytes-size)
+			if err != nil {
+				return err
+			}
+			size += archiveSize
+		} else {
+			size += info.Size()
+		}
+
+		if size > hr.maxBytes {
+			return tooLarge
+		}
+		return nil
+	})
+	if err != nil && err != tooLarge {
+		return &HelmError{Chart: filepath.Base(chartPath), Code: ErrCodeChartRenderFailed, Err: err}
+	}
+
+	return err
+}
+
+// archiveSize returns the unpacked size of the files in the gzipped tarball at
+// archivePath, reading it as a stream. It stops once the size is over limit.
+func archiveSize(archivePath string, limit int64) (int64, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+	}
+	defer gzipReader.Close()
+
+	var size int64
+	tarReader := tar.NewReader(gzipReader)
+	for size <= limit {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+		}
+		size += header.Size
+	}
+
+	return size, nil
+}
@@ -0,0 +1,181 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// ErrCodeKustomizeFailed is the code of a KustomizeError, returned to API
+// clients in APIError.
+const ErrCodeKustomizeFailed = "kustomize_render_failed"
+
+// KustomizeError reports a kustomization that could not be built.
+type KustomizeError struct {
+	// Dir is the directory of the kustomization, relative to the root of the
+	// repository.
+	Dir string
+	Err error
+}
+
+func (e *KustomizeError) Error() string {
+	return fmt.Sprintf("kustomize build %s: %s", e.Dir, e.Err.Error())
+}
+
+func (e *KustomizeError) Unwrap() error {
+	return e.Err
+}
+
+// kustomizationRefs is the part of a kustomization referring to other files
+// and kustomizations.
+type kustomizationRefs struct {
+	Resources  []string `yaml:"resources"`
+	Bases      []string `yaml:"bases"`
+	Components []string `yaml:"components"`
+}
+
+// IsKustomizationFile reports whether the file at filePath is named like a
+// kustomization.
+func IsKustomizationFile(filePath string) bool {
+	base := path.Base(filePath)
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if base == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderKustomization builds the kustomization in dir of tree in memory, the
+// way kustomize build does, and returns the resulting manifest.
+func renderKustomization(tree *object.Tree, dir string) (string, error) {
+	fSys := filesys.MakeFsInMemory()
+	if err := copyKustomization(tree, fSys, dir); err != nil {
+		return "", &KustomizeError{Dir: dir, Err: err}
+	}
+
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fSys, "/"+dir)
+	if err != nil {
+		return "", &KustomizeError{Dir: dir, Err: err}
+	}
+
+	manifest, err := resources.AsYaml()
+	if err != nil {
+		return "", &KustomizeError{Dir: dir, Err: err}
+	}
+
+	return string(manifest), nil
+}
+
+// copyKustomization copies the files the kustomization in dir needs from tree
+// into fSys: the files under dir and the resources, bases and components it
+// refers to, recursively. Kustomize loads anything it cannot find as a remote
+// base, so references to files missing from the repository are rejected here.
+func copyKustomization(tree *object.Tree, fSys filesys.FileSystem, dir string) error {
+	visited := make(map[string]bool)
+
+	var visit func(dir string) error
+	visit = func(dir string) error {
+		if visited[dir] {
+			return nil
+		}
+		visited[dir] = true
+
+		if err := copyTreeDir(tree, fSys, dir); err != nil {
+			return err
+		}
+
+		refs, err := readKustomizationRefs(tree, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range append(append(refs.Resources, refs.Bases...), refs.Components...) {
+			refPath, ok := repoRelativeDir(path.Join(dir, ref))
+			if !ok || strings.Contains(ref, "://") {
+				return fmt.Errorf("%s refers to %s outside of the repository, remote bases are not supported", dir, ref)
+			}
+
+			if file, err := tree.File(refPath); err == nil {
+				if err := copyTreeFile(fSys, file, refPath); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := tree.Tree(refPath); err != nil && refPath != "" {
+				return fmt.Errorf("%s refers to %s, which is not in the repository, remote bases are not supported", dir, ref)
+			}
+
+			if err := visit(refPath); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return visit(dir)
+}
+
+func readKustomizationRefs(tree *object.Tree, dir string) (*kustomizationRefs, error) {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		file, err := tree.File(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		contents, err := file.Contents()
+		if err != nil {
+			return nil, err
+		}
+
+		refs := &kustomizationRefs{}
+		if err := yaml.Unmarshal([]byte(contents), refs); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+
+		return refs, nil
+	}
+
+	return nil, fmt.Errorf("no kustomization found in %s", dir)
+}
+
+// copyTreeDir copies the files under dir of tree into fSys.
+func copyTreeDir(tree *object.Tree, fSys filesys.FileSystem, dir string) error {
+	subtree := tree
+	if dir != "" {
+		var err error
+		subtree, err = tree.Tree(dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	return subtree.Files().ForEach(func(file *object.File) error {
+		return copyTreeFile(fSys, file, path.Join(dir, file.Name))
+	})
+}
+
+// copyTreeFile writes file to filePath in fSys. Symlinks are skipped, they
+// could point anywhere on the host.
+func copyTreeFile(fSys filesys.FileSystem, file *object.File, filePath string) error {
+	if file.Mode == filemode.Symlink {
+		return nil
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return err
+	}
+
+	return fSys.WriteFile("/"+filePath, []byte(contents))
+}
@@ -0,0 +1,108 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// GitError reports a failed git operation on a local checkout.
+type GitError struct {
+	// Op is the operation that failed, such as clone or checkout.
+	Op string
+	// Repo is the checkout, as owner/name@branch.
+	Repo string
+	Err  error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s %s: %s", e.Op, e.Repo, Redact(e.Err.Error()))
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// gitRemote is a clone URL split into the URL stored as the remote of the
+// checkout and the credentials embedded in it, which are only handed to the
+// transport, so they never reach .git/config.
+type gitRemote struct {
+	url      string
+	auth     transport.AuthMethod
+	caBundle []byte
+}
+
+// newGitRemote prepares the options needed to reach the host of cloneURL.
+// The caller must hold the service lock.
+func (lrs *LocalRepoService) newGitRemote(cloneURL string) gitRemote {
+	remote := gitRemote{url: cloneURL}
+
+	parsedURL, err := url.Parse(cloneURL)
+	if err != nil {
+		return remote
+	}
+
+	remote.caBundle = lrs.gitCABundles[parsedURL.Host]
+
+	if parsedURL.User != nil {
+		password, _ := parsedURL.User.Password()
+		remote.auth = &githttp.BasicAuth{
+			Username: parsedURL.User.Username(),
+			Password: password,
+		}
+		parsedURL.User = nil
+		remote.url = parsedURL.String()
+	}
+
+	return remote
+}
+
+// cloneBranch makes a single branch, shallow clone of the branch into dir. A
+// sparse clone fills the index but leaves the worktree empty, for the caller
+// to check out the directories it needs.
+func (remote gitRemote) cloneBranch(ctx context.Context, dir, repoBranch string, sparse bool) (*git.Repository, error) {
+	repo, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+		URL:           remote.url,
+		Auth:          remote.auth,
+		CABundle:      remote.caBundle,
+		ReferenceName: plumbing.NewBranchReferenceName(repoBranch),
+		SingleBranch:  true,
+		Depth:         1,
+		Tags:          git.NoTags,
+		NoCheckout:    sparse,
+	})
+	if err != nil || !sparse {
+		return repo, err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, resetIndex(repo, head.Hash())
+}
+
+// resetIndex points the index at commit without touching the worktree.
+func resetIndex(repo *git.Repository, commit plumbing.Hash) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	return worktree.Reset(&git.ResetOptions{Commit: commit, Mode: git.MixedReset})
+}
+
+func gitHeadSHA(repo *git.Repository) (string, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+
+	return head.Hash().String(), nil
+}
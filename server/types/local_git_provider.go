@@ -0,0 +1,405 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v38/github"
+)
+
+// LocalGitProvider implements SourceControlProvider on top of git repositories
+// on disk. Repositories are looked up as <root>/<owner>/<name> or, for bare
+// repositories, <root>/<owner>/<name>.git. Commits are written with git
+// plumbing so working trees are never touched, and "pull requests" are plain
+// branches left in the repository.
+type LocalGitProvider struct {
+	root string
+}
+
+// NewLocalGitProvider accepts either a directory or a file:// URL as the root
+// holding the repositories.
+func NewLocalGitProvider(root string) (*LocalGitProvider, error) {
+	root = strings.TrimPrefix(root, "file://")
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("[NewLocalGitProvider] invalid repository root %s: %s", root, err.Error())
+	}
+
+	info, err := os.Stat(absRoot)
+	if err != nil {
+		return nil, fmt.Errorf("[NewLocalGitProvider] invalid repository root %s: %s", root, err.Error())
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("[NewLocalGitProvider] repository root %s is not a directory", root)
+	}
+
+	log.Println("Using local git repositories in: ", absRoot)
+	return &LocalGitProvider{
+		root: absRoot,
+	}, nil
+}
+
+func (lgp *LocalGitProvider) ListRepositories(ctx context.Context, repoOwner string) ([]*github.Repository, error) {
+	log.Printf("[ListRepositories] Listing local repositories: %s\n", repoOwner)
+	ownerDir := filepath.Join(lgp.root, filepath.Clean("/"+repoOwner))
+	dirEntries, err := ioutil.ReadDir(ownerDir)
+	if err != nil {
+		return nil, fmt.Errorf("[ListRepositories] error listing repositories: %s", err.Error())
+	}
+
+	repos := make([]*github.Repository, 0)
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+
+		repoDir := filepath.Join(ownerDir, dirEntry.Name())
+		if _, err := runGit(ctx, repoDir, nil, nil, "rev-parse", "--git-dir"); err != nil {
+			continue
+		}
+
+		repos = append(repos, &github.Repository{
+			ID:       github.Int64(int64(len(repos) + 1)),
+			Name:     github.String(strings.TrimSuffix(dirEntry.Name(), ".git")),
+			FullName: github.String(repoOwner + "/" + strings.TrimSuffix(dirEntry.Name(), ".git")),
+			HTMLURL:  github.String("file://" + repoDir),
+			Owner:    &github.User{Login: github.String(repoOwner)},
+		})
+	}
+
+	return repos, nil
+}
+
+func (lgp *LocalGitProvider) ListRepositoryBranches(ctx context.Context, repoOwner, repoName string) ([]*github.Branch, error) {
+	log.Println("[ListRepositoryBranches] Listing local branches: ", repoName)
+	repoDir, err := lgp.repoDir(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[ListRepositoryBranches] error fetching branches %s: %s", repoName, err.Error())
+	}
+
+	out, err := runGit(ctx, repoDir, nil, nil, "for-each-ref", "--format=%(objectname) %(refname:short)", "refs/heads")
+	if err != nil {
+		return nil, fmt.Errorf("[ListRepositoryBranches] error fetching branches %s: %s", repoName, err.Error())
+	}
+
+	branches := make([]*github.Branch, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		branches = append(branches, &github.Branch{
+			Name:   github.String(fields[1]),
+			Commit: &github.RepositoryCommit{SHA: github.String(fields[0])},
+		})
+	}
+
+	return branches, nil
+}
+
+func (lgp *LocalGitProvider) GetRepositoryBranch(ctx context.Context, repoOwner, repoName, branchName string) (*github.Branch, error) {
+	log.Println("[GetRepositoryBranch] Resolving local branch: ", branchName)
+	repoDir, err := lgp.repoDir(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[GetRepositoryBranch] error fetching branch %s: %s", branchName, err.Error())
+	}
+
+	sha, err := lgp.resolve(ctx, repoDir, "refs/heads/"+branchName)
+	if err != nil {
+		return nil, fmt.Errorf("[GetRepositoryBranch] error fetching branch %s: %s", branchName, err.Error())
+	}
+
+	return &github.Branch{
+		Name:   github.String(branchName),
+		Commit: &github.RepositoryCommit{SHA: github.String(sha)},
+	}, nil
+}
+
+// GetBranchTree lists the recursive tree of a branch, commit or tree SHA,
+// including directory entries like the GitHub recursive tree API.
+func (lgp *LocalGitProvider) GetBranchTree(ctx context.Context, repoOwner, repoName, branchSHA string) (*github.Tree, error) {
+	log.Println("[GetBranchTree] Listing Branch Tree: ", branchSHA)
+	repoDir, err := lgp.repoDir(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s", err.Error())
+	}
+
+	return lgp.listTree(ctx, repoDir, branchSHA)
+}
+
+func (lgp *LocalGitProvider) GetBlob(ctx context.Context, repoOwner, repoName, blobSHA string) (*github.Blob, error) {
+	repoDir, err := lgp.repoDir(repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("[GetBlob] error fetching blob: %s", err.Error())
+	}
+
+	// cat-file takes no "--", so anything but a SHA could be read as an option.
+	if !isObjectSHA(blobSHA) {
+		return nil, fmt.Errorf("[GetBlob] invalid blob SHA %q", blobSHA)
+	}
+
+	contents, err := runGit(ctx, repoDir, nil, nil, "cat-file", "blob", blobSHA)
+	if err != nil {
+		return nil, fmt.Errorf("[GetBlob] error fetching blob: %s", err.Error())
+	}
+
+	return &github.Blob{
+		SHA:      github.String(blobSHA),
+		Content:  github.String(base64.StdEncoding.EncodeToString(contents)),
+		Encoding: github.String("base64"),
+		Size:     github.Int(len(contents)),
+	}, nil
+}
+
+func (lgp *LocalGitProvider) GetReference(ctx context.Context, sourceOwner, sourceRepo, commitBranch, baseBranch string) (*github.Reference, error) {
+	repoDir, err := lgp.repoDir(sourceOwner, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if sha, err := lgp.resolve(ctx, repoDir, "refs/heads/"+commitBranch); err == nil {
+		return localReference(commitBranch, sha), nil
+	}
+
+	// We consider that an error means the branch has not been found and needs to
+	// be created.
+	if commitBranch == baseBranch {
+		return nil, fmt.Errorf("The commit branch does not exist but `-base-branch` is the same as `-commit-branch`")
+	}
+
+	if baseBranch == "" {
+		return nil, fmt.Errorf("The `-base-branch` should not be set to an empty string when the branch specified by `-commit-branch` does not exists")
+	}
+
+	baseSHA, err := lgp.resolve(ctx, repoDir, "refs/heads/"+baseBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := runGit(ctx, repoDir, nil, nil, "update-ref", "refs/heads/"+commitBranch, baseSHA, ""); err != nil {
+		return nil, fmt.Errorf("[GetReference] error creating branch %s: %s", commitBranch, err.Error())
+	}
+
+	return localReference(commitBranch, baseSHA), nil
+}
+
+// GenerateCommitTree writes the changed files as blobs and builds a new tree
+// on top of the commit of ref using a throwaway index file.
+func (lgp *LocalGitProvider) GenerateCommitTree(ctx context.Context, ref *github.Reference, sourceOwner, sourceRepo string, changes []FileChange) (*github.Tree, error) {
+	repoDir, err := lgp.repoDir(sourceOwner, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	indexFile, err := ioutil.TempFile("", "k8s-tools-index-")
+	if err != nil {
+		return nil, fmt.Errorf("[GenerateCommitTree] error creating index: %s", err.Error())
+	}
+	indexFile.Close()
+	defer os.Remove(indexFile.Name())
+	env := []string{"GIT_INDEX_FILE=" + indexFile.Name()}
+
+	if _, err := runGit(ctx, repoDir, env, nil, "read-tree", ref.GetObject().GetSHA()); err != nil {
+		return nil, fmt.Errorf("[GenerateCommitTree] error reading base tree: %s", err.Error())
+	}
+
+	for _, change := range changes {
+		if change.Delete {
+			if _, err := runGit(ctx, repoDir, env, nil, "update-index", "--force-remove", "--", change.Path); err != nil {
+				return nil, fmt.Errorf("[GenerateCommitTree] error removing %s: %s", change.Path, err.Error())
+			}
+			continue
+		}
+
+		blobSHA, err := runGit(ctx, repoDir, env, change.Content, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return nil, fmt.Errorf("[GenerateCommitTree] error writing blob %s: %s", change.Path, err.Error())
+		}
+
+		cacheInfo := fmt.Sprintf("%s,%s,%s", change.Mode, strings.TrimSpace(string(blobSHA)), change.Path)
+		if _, err := runGit(ctx, repoDir, env, nil, "update-index", "--add", "--cacheinfo", cacheInfo); err != nil {
+			return nil, fmt.Errorf("[GenerateCommitTree] error staging %s: %s", change.Path, err.Error())
+		}
+	}
+
+	treeSHA, err := runGit(ctx, repoDir, env, nil, "write-tree")
+	if err != nil {
+		return nil, fmt.Errorf("[GenerateCommitTree] error writing tree: %s", err.Error())
+	}
+
+	return lgp.listTree(ctx, repoDir, strings.TrimSpace(string(treeSHA)))
+}
+
+func (lgp *LocalGitProvider) CreateCommit(ctx context.Context, ref *github.Reference, tree *github.Tree, sourceOwner, sourceRepo string) (*github.Commit, error) {
+	repoDir, err := lgp.repoDir(sourceOwner, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []string{
+		"GIT_AUTHOR_NAME=" + commitName,
+		"GIT_AUTHOR_EMAIL=" + commitEmail,
+		"GIT_COMMITTER_NAME=" + commitName,
+		"GIT_COMMITTER_EMAIL=" + commitEmail,
+	}
+
+	parentSHA := ref.GetObject().GetSHA()
+	commitSHA, err := runGit(ctx, repoDir, env, nil, "commit-tree", tree.GetSHA(), "-p", parentSHA, "-m", commitMessage)
+	if err != nil {
+		return nil, fmt.Errorf("[CreateCommit] error creating commit: %s", err.Error())
+	}
+	sha := strings.TrimSpace(string(commitSHA))
+
+	// Attach the commit to the branch, failing if it moved in the meantime.
+	if _, err := runGit(ctx, repoDir, nil, nil, "update-ref", ref.GetRef(), sha, parentSHA); err != nil {
+		return nil, fmt.Errorf("[CreateCommit] error updating %s: %s", ref.GetRef(), err.Error())
+	}
+	ref.Object.SHA = github.String(sha)
+
+	return &github.Commit{
+		SHA:     github.String(sha),
+		Message: github.String(commitMessage),
+		Tree:    tree,
+		Author:  &github.CommitAuthor{Name: &commitName, Email: &commitEmail},
+	}, nil
+}
+
+// CreatePullRequest does not open anything: the commit branch created by
+// GetReference is the result, and the returned URL points at it.
+func (lgp *LocalGitProvider) CreatePullRequest(ctx context.Context, prSubject, prRepoOwner, prRepo, prBranch, prDescription, sourceOwner, sourceRepo, commitBranch string) (*github.PullRequest, error) {
+	if prSubject == "" {
+		return nil, errors.New("missing `-pr-title` flag; skipping PR creation")
+	}
+
+	repoDir, err := lgp.repoDir(sourceOwner, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := lgp.resolve(ctx, repoDir, "refs/heads/"+commitBranch); err != nil {
+		return nil, fmt.Errorf("[CreatePullRequest] branch %s does not exist", commitBranch)
+	}
+
+	log.Printf("Branch %s created in %s for: %s", commitBranch, repoDir, prSubject)
+	return &github.PullRequest{
+		State:   github.String("open"),
+		Title:   github.String(prSubject),
+		Body:    github.String(prDescription),
+		HTMLURL: github.String(fmt.Sprintf("file://%s#%s", repoDir, commitBranch)),
+		Head:    &github.PullRequestBranch{Ref: github.String(commitBranch)},
+		Base:    &github.PullRequestBranch{Ref: github.String(prBranch)},
+	}, nil
+}
+
+func (lgp *LocalGitProvider) CloneURL(repoOwner, repoName string) string {
+	repoDir, err := lgp.repoDir(repoOwner, repoName)
+	if err != nil {
+		return ""
+	}
+
+	return "file://" + repoDir
+}
+
+func (lgp *LocalGitProvider) repoDir(repoOwner, repoName string) (string, error) {
+	repoDir := filepath.Join(lgp.root, filepath.Clean("/"+repoOwner), filepath.Clean("/"+repoName))
+	for _, candidate := range []string{repoDir, repoDir + ".git"} {
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("repository %s/%s not found", repoOwner, repoName)
+}
+
+func (lgp *LocalGitProvider) resolve(ctx context.Context, repoDir, rev string) (string, error) {
+	sha, err := runGit(ctx, repoDir, nil, nil, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("revision %s not found", rev)
+	}
+
+	return strings.TrimSpace(string(sha)), nil
+}
+
+func (lgp *LocalGitProvider) listTree(ctx context.Context, repoDir, treeish string) (*github.Tree, error) {
+	treeSHA, err := runGit(ctx, repoDir, nil, nil, "rev-parse", "--verify", "--quiet", treeish+"^{tree}")
+	if err != nil {
+		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s not found", treeish)
+	}
+
+	// Without -z paths holding quotes, tabs or non-ASCII bytes are C-quoted.
+	out, err := runGit(ctx, repoDir, nil, nil, "ls-tree", "-r", "-t", "-l", "-z", "--full-tree", strings.TrimSpace(string(treeSHA)))
+	if err != nil {
+		return nil, fmt.Errorf("[GetBranchTree] error fetching tree: %s", err.Error())
+	}
+
+	entries := make([]*github.TreeEntry, 0)
+	for _, record := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> SP <size> TAB <path>
+		line := strings.SplitN(record, "\t", 2)
+		if len(line) != 2 {
+			continue
+		}
+
+		fields := strings.Fields(line[0])
+		if len(fields) != 4 {
+			continue
+		}
+
+		entry := &github.TreeEntry{
+			Mode: github.String(fields[0]),
+			Type: github.String(fields[1]),
+			SHA:  github.String(fields[2]),
+			Path: github.String(line[1]),
+		}
+		if size, err := strconv.Atoi(fields[3]); err == nil {
+			entry.Size = github.Int(size)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return &github.Tree{
+		SHA:       github.String(strings.TrimSpace(string(treeSHA))),
+		Entries:   entries,
+		Truncated: github.Bool(false),
+	}, nil
+}
+
+func localReference(branch, sha string) *github.Reference {
+	return &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha), Type: github.String("commit")},
+	}
+}
+
+// runGit runs a git command in dir and returns its stdout. Stderr is folded
+// into the returned error so failures are not reduced to an exit status.
+func runGit(ctx context.Context, dir string, env []string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s: %s", args[0], err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// oddPath needs quoting in git's default output.
+const oddPath = "deploy/café \"a\"\tb.yaml"
+
+// newLocalGitRoot creates <root>/owner/repo.git, a bare repository whose main
+// branch holds oddPath, and returns root.
+func newLocalGitRoot(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	work := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(work, "deploy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(work, filepath.FromSlash(oddPath)), []byte("kind: Service\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	bare := filepath.Join(root, fixtureOwner, fixtureRepo+".git")
+	for _, args := range [][]string{
+		{"-C", work, "init", "-q", "-b", "main"},
+		{"-C", work, "add", "-A"},
+		{"-C", work, "-c", "user.name=" + commitName, "-c", "user.email=" + commitEmail, "commit", "-q", "-m", "fixture"},
+		{"clone", "-q", "--bare", work, bare},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	return root
+}
+
+func TestLocalGitProviderCommitToBranch(t *testing.T) {
+	ctx := context.Background()
+	lgp, err := NewLocalGitProvider(newLocalGitRoot(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := lgp.GetRepositoryBranch(ctx, fixtureOwner, fixtureRepo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := lgp.GetReference(ctx, fixtureOwner, fixtureRepo, "feature", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.GetObject().GetSHA() != base.GetCommit().GetSHA() {
+		t.Fatalf("expected feature to start at main, got %s", ref.GetObject().GetSHA())
+	}
+
+	newPath := "deploy/ingress é.yaml"
+	changes, err := CleanFileChanges([]FileChange{{Path: newPath, Content: []byte("kind: Ingress\n")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lgp.GenerateCommitTree(ctx, ref, fixtureOwner, fixtureRepo, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string]string{}
+	for _, entry := range tree.Entries {
+		entries[entry.GetPath()] = entry.GetSHA()
+	}
+	for _, wantPath := range []string{"deploy", oddPath, newPath} {
+		if _, ok := entries[wantPath]; !ok {
+			t.Errorf("expected %q in the tree, got %v", wantPath, entries)
+		}
+	}
+
+	blob, err := lgp.GetBlob(ctx, fixtureOwner, fixtureRepo, entries[newPath])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := base64.StdEncoding.DecodeString(blob.GetContent()); string(content) != "kind: Ingress\n" {
+		t.Errorf("unexpected blob content %q", content)
+	}
+
+	commit, err := lgp.CreateCommit(ctx, ref, tree, fixtureOwner, fixtureRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feature, err := lgp.GetRepositoryBranch(ctx, fixtureOwner, fixtureRepo, "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feature.GetCommit().GetSHA() != commit.GetSHA() {
+		t.Errorf("expected feature at the new commit %s, got %s", commit.GetSHA(), feature.GetCommit().GetSHA())
+	}
+
+	branchTree, err := lgp.GetBranchTree(ctx, fixtureOwner, fixtureRepo, "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branchTree.GetSHA() != tree.GetSHA() {
+		t.Errorf("expected feature to hold tree %s, got %s", tree.GetSHA(), branchTree.GetSHA())
+	}
+
+	main, err := lgp.GetRepositoryBranch(ctx, fixtureOwner, fixtureRepo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main.GetCommit().GetSHA() != base.GetCommit().GetSHA() {
+		t.Error("expected main to be left alone")
+	}
+}
@@ -1,32 +1,124 @@
 package types
 
 import (
+	"context"
 	"crypto/sha1"
-	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
 	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"helm.sh/helm/v3/pkg/cli"
 )
 
+const (
+	// defaultCheckoutDir is the directory in the system temp directory
+	// checkouts are kept in when no work directory is configured.
+	defaultCheckoutDir = "k8s-tools-checkouts"
+
+	checkoutDirPrefix = "checkout-"
+
+	// checkoutWatchInterval is how often a clone in flight is measured against
+	// the checkout quota.
+	checkoutWatchInterval = 200 * time.Millisecond
+
+	// renderedManifestsMaxBytes bounds the memory holding rendered manifests.
+	renderedManifestsMaxBytes = 64 << 20
+)
+
+// checkoutDirPattern matches the directories of checkouts, including those
+// being replaced or removed.
+var checkoutDirPattern = regexp.MustCompile(`^` + checkoutDirPrefix + `[0-9a-f]{40}(\.(refresh|removing)-[0-9]+)?$`)
+
+// LocalRepoService keeps local clones of repositories, used to render Helm
+// charts. It is safe for concurrent use: a repo is
+// cloned once even when requested concurrently, work inside a checkout is
+// serialized, and cleanup skips checkouts in use.
 type LocalRepoService struct {
+	mu        sync.Mutex
 	repoCache map[string]*LocalRepo
+	workDir   string
+	cacheTTL  time.Duration
+	helm      *helmRenderer
+	// manifests holds the rendered charts and kustomizations keyed by
+	// checkout, commit SHA and the arguments of the render.
+	manifests *ObjectCache
+	// gitCABundles maps a clone host to the PEM encoded certificate
+	// authorities trusted for it.
+	gitCABundles map[string][]byte
+	// sparse makes clones check out only the charts being rendered.
+	sparse bool
+	// maxCheckoutBytes and maxWorkDirBytes cap the disk used by a checkout and
+	// by all of them, zero means no cap.
+	maxCheckoutBytes int64
+	maxWorkDirBytes  int64
 }
 
 type LocalRepo struct {
-	Name                string
-	Owner               string
-	Branch              string
-	BaseDirectory       string
-	Manifest            string
+	Name          string
+	Owner         string
+	Branch        string
+	BaseDirectory string
+	// CommitSHA is the commit the checkout is at.
+	CommitSHA           string
 	CloneTimestamp      time.Time
 	LastActionTimestamp time.Time
+
+	// ready is closed once the clone finished, cloneErr is set if it failed.
+	ready    chan struct{}
+	cloneErr error
+	repo     *git.Repository
+	// inUse counts the operations running in the checkout, guarded by the
+	// service lock. work serializes them.
+	inUse int
+	work  sync.Mutex
+	// sparseDirs are the directories checked out in a sparse checkout, nil
+	// for a full checkout. Guarded by work.
+	sparseDirs map[string]bool
+	// diskUsage is the size of the checkout, guarded by the service lock.
+	diskUsage int64
 }
 
-func NewLocalRepoService() LocalRepoService {
-	newService := LocalRepoService{
-		repoCache: make(map[string]*LocalRepo),
+// NewLocalRepoService keeps clones under workDir, a directory in the system
+// temp directory when empty, and removes the ones unused for longer than
+// cacheTTL. Checkouts left behind by a previous run are removed.
+func NewLocalRepoService(workDir string, cacheTTL time.Duration) (*LocalRepoService, error) {
+	if workDir == "" {
+		workDir = filepath.Join(os.TempDir(), defaultCheckoutDir)
+	}
+
+	workDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return nil, fmt.Errorf("[NewLocalRepoService] invalid work directory: %s", err.Error())
+	}
+
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		return nil, fmt.Errorf("[NewLocalRepoService] failed to create work directory: %s", err.Error())
+	}
+
+	newService := &LocalRepoService{
+		repoCache:    make(map[string]*LocalRepo),
+		workDir:      workDir,
+		cacheTTL:     cacheTTL,
+		helm:         &helmRenderer{settings: cli.New()},
+		gitCABundles: make(map[string][]byte),
+	}
+	newService.manifests, err = NewObjectCache(renderedManifestsMaxBytes, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := newService.removeOrphanedCheckouts(); err != nil {
+		return nil, err
 	}
 
 	go func() {
@@ -35,21 +127,137 @@ func NewLocalRepoService() LocalRepoService {
 		}
 	}()
 
-	return newService
+	return newService, nil
 }
 
-func (lrs *LocalRepoService) CloneRepositoryLocaly(accessToken, repoOwner, repoName, repoBranch string) error {
-	repoHash := lrs.generateRepoHash(repoOwner, repoName, repoBranch)
+// removeOrphanedCheckouts removes the checkouts of a previous run from the
+// work directory. Only entries named like a checkout are touched, so a work
+// directory shared with other files is safe.
+func (lrs *LocalRepoService) removeOrphanedCheckouts() error {
+	entries, err := ioutil.ReadDir(lrs.workDir)
+	if err != nil {
+		return fmt.Errorf("[NewLocalRepoService] failed to read work directory: %s", err.Error())
+	}
 
-	if _, ok := lrs.repoCache[repoHash]; ok {
-		log.Println("[CloneRepository] Repo alreade present locally")
+	for _, entry := range entries {
+		if !checkoutDirPattern.MatchString(entry.Name()) {
+			continue
+		}
+
+		log.Println("[NewLocalRepoService] Removing orphaned checkout: ", entry.Name())
+		if err := lrs.removeCheckoutDir(filepath.Join(lrs.workDir, entry.Name())); err != nil {
+			log.Println("[NewLocalRepoService] Failed to remove orphaned checkout: ", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// removeCheckoutDir removes dir after checking that it is a checkout inside
+// the work directory.
+func (lrs *LocalRepoService) removeCheckoutDir(dir string) error {
+	rel, err := filepath.Rel(lrs.workDir, dir)
+	if err != nil || rel != filepath.Base(dir) || !checkoutDirPattern.MatchString(rel) {
+		return fmt.Errorf("refusing to remove %s, it is not a checkout in %s", dir, lrs.workDir)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// AddGitCABundle trusts the certificate authorities in the PEM file caBundle
+// when cloning from host. An empty caBundle is ignored.
+func (lrs *LocalRepoService) AddGitCABundle(host, caBundle string) error {
+	if caBundle == "" {
 		return nil
 	}
 
-	err := exec.Command("git", "clone", "-b", repoBranch, fmt.Sprintf("https://%s@github.com/%s/%s.git", accessToken, repoOwner, repoName), fmt.Sprintf("./%s", repoHash)).Run()
+	caPEM, err := ioutil.ReadFile(caBundle)
 	if err != nil {
-		log.Println("[CloneRepository] Failed to clone repository: ", err.Error())
-		return err
+		return fmt.Errorf("[AddGitCABundle] failed to read CA bundle %s: %s", caBundle, err.Error())
+	}
+
+	lrs.mu.Lock()
+	defer lrs.mu.Unlock()
+	lrs.gitCABundles[host] = caPEM
+	return nil
+}
+
+// ConfigureCheckouts sets whether clones are sparse and the disk quotas of a
+// checkout and of the work directory, zero disables a quota. When the work
+// directory is over its quota, idle checkouts are removed to make room.
+func (lrs *LocalRepoService) ConfigureCheckouts(sparse bool, maxCheckoutBytes, maxWorkDirBytes int64) {
+	lrs.mu.Lock()
+	defer lrs.mu.Unlock()
+	lrs.sparse = sparse
+	lrs.maxCheckoutBytes = maxCheckoutBytes
+	lrs.maxWorkDirBytes = maxWorkDirBytes
+}
+
+// ConfigureHelm sets the timeout of a chart render and the size a chart, with
+// its dependencies, and a rendered manifest may have. Zero disables either
+// limit.
+func (lrs *LocalRepoService) ConfigureHelm(timeout time.Duration, maxBytes int64) {
+	lrs.mu.Lock()
+	defer lrs.mu.Unlock()
+	lrs.helm = &helmRenderer{settings: lrs.helm.settings, timeout: timeout, maxBytes: maxBytes}
+}
+
+// Checkout is a checkout held for a caller of CloneRepositoryLocaly. It is
+// not evicted until Release is called.
+type Checkout struct {
+	lrs         *LocalRepoService
+	localRepo   *LocalRepo
+	releaseOnce sync.Once
+}
+
+// Release lets the checkout be evicted again. It may be called more than once.
+func (c *Checkout) Release() {
+	c.releaseOnce.Do(func() {
+		c.lrs.release(c.localRepo)
+	})
+}
+
+// CloneRepositoryLocaly clones the branch unless it is already present, and
+// holds the checkout for the caller, who renders on it and must release it.
+// While a clone is in flight, other requests for the same branch wait for it
+// and share its result. headSHA is the current head of the branch, an
+// existing checkout at another commit is replaced by a fresh clone of it. An
+// empty headSHA skips the check.
+func (lrs *LocalRepoService) CloneRepositoryLocaly(cloneURL, repoOwner, repoName, repoBranch, headSHA string) (*Checkout, error) {
+	repoHash := lrs.generateRepoHash(repoOwner, repoName, repoBranch)
+
+	lrs.mu.Lock()
+	if localRepo, ok := lrs.repoCache[repoHash]; ok {
+		// The checkout is marked in use while waiting for the clone, so cleanup
+		// cannot evict it between the end of the clone and the render.
+		localRepo.inUse++
+		localRepo.LastActionTimestamp = time.Now()
+		lrs.mu.Unlock()
+		checkout := &Checkout{lrs: lrs, localRepo: localRepo}
+
+		<-localRepo.ready
+		if localRepo.cloneErr != nil {
+			checkout.Release()
+			return nil, localRepo.cloneErr
+		}
+		if headSHA == "" {
+			return checkout, nil
+		}
+
+		localRepo.work.Lock()
+		err := lrs.refreshCheckout(localRepo, cloneURL, repoBranch, headSHA)
+		localRepo.work.Unlock()
+		if err != nil {
+			checkout.Release()
+			return nil, err
+		}
+
+		return checkout, nil
+	}
+
+	if cloneURL == "" {
+		lrs.mu.Unlock()
+		return nil, fmt.Errorf("[CloneRepository] provider does not support cloning %s/%s", repoOwner, repoName)
 	}
 
 	newLocalRepo := &LocalRepo{
@@ -59,99 +267,511 @@ func (lrs *LocalRepoService) CloneRepositoryLocaly(accessToken, repoOwner, repoN
 		BaseDirectory:       repoHash,
 		CloneTimestamp:      time.Now(),
 		LastActionTimestamp: time.Now(),
-		Manifest:            "",
+		ready:               make(chan struct{}),
+		inUse:               1,
+	}
+	if lrs.sparse {
+		newLocalRepo.sparseDirs = make(map[string]bool)
 	}
-
-	log.Println("[CloneRepository] Successfully cloned repo")
 	lrs.repoCache[repoHash] = newLocalRepo
+	remote := lrs.newGitRemote(cloneURL)
+	lrs.mu.Unlock()
+
+	repoDir := filepath.Join(lrs.workDir, repoHash)
+	repo, err := lrs.cloneWithinQuota(remote, repoDir, repoBranch, newLocalRepo.sparseDirs != nil)
+	if err == nil {
+		newLocalRepo.repo = repo
+		newLocalRepo.CommitSHA, err = gitHeadSHA(repo)
+	}
+	if err != nil {
+		err = &GitError{Op: "clone", Repo: newLocalRepo.String(), Err: err}
+	} else {
+		err = lrs.checkDiskQuota(newLocalRepo)
+	}
+
+	// A failed clone is renamed out of the way under the lock, so a new clone of
+	// the branch cannot collide with it, and removed once the lock is released.
+	dirsToRemove := make(map[string]string)
+	lrs.mu.Lock()
+	if err != nil {
+		log.Println("[CloneRepository] Failed to clone repository: ", err.Error())
+		newLocalRepo.inUse--
+		newLocalRepo.cloneErr = err
+		if removedDir, ok := lrs.evictLocked(repoHash, newLocalRepo); ok {
+			dirsToRemove[repoHash] = removedDir
+		}
+		delete(lrs.repoCache, repoHash)
+	} else {
+		log.Println("[CloneRepository] Successfully cloned repo")
+		newLocalRepo.LastActionTimestamp = time.Now()
+	}
+	lrs.mu.Unlock()
+
+	close(newLocalRepo.ready)
+	lrs.removeCheckouts(dirsToRemove)
+	if err != nil {
+		return nil, err
+	}
+
+	// The clone's mark of the checkout as in use is handed to the caller.
+	return &Checkout{lrs: lrs, localRepo: newLocalRepo}, nil
+}
+
+// refreshCheckout replaces the checkout with a fresh clone when it is not at
+// headSHA. A shallow clone cannot be fetched into, so the branch is cloned
+// next to the checkout and swapped in, dropping local branches and commits.
+// The clone uses the credentials of cloneURL, as those used for the first
+// clone may have expired. The caller must hold the work lock of the checkout.
+func (lrs *LocalRepoService) refreshCheckout(localRepo *LocalRepo, cloneURL, repoBranch, headSHA string) error {
+	if localRepo.CommitSHA == headSHA {
+		log.Println("[CloneRepository] Repo alreade present locally")
+		return nil
+	}
+
+	log.Printf("[CloneRepository] Branch moved from %s to %s, refreshing local repo\n", localRepo.CommitSHA, headSHA)
+	lrs.mu.Lock()
+	remote := lrs.newGitRemote(cloneURL)
+	lrs.mu.Unlock()
+
+	repoDir := filepath.Join(lrs.workDir, localRepo.BaseDirectory)
+	freshDir := fmt.Sprintf("%s.refresh-%d", repoDir, time.Now().UnixNano())
+	if _, err := lrs.cloneWithinQuota(remote, freshDir, repoBranch, localRepo.sparseDirs != nil); err != nil {
+		lrs.removeCheckoutDir(freshDir)
+		return &GitError{Op: "clone", Repo: localRepo.String(), Err: err}
+	}
+
+	staleDir := fmt.Sprintf("%s.removing-%d", repoDir, time.Now().UnixNano())
+	if err := os.Rename(repoDir, staleDir); err != nil {
+		lrs.removeCheckoutDir(freshDir)
+		return fmt.Errorf("[CloneRepository] failed to replace checkout: %s", err.Error())
+	}
+	if err := os.Rename(freshDir, repoDir); err != nil {
+		os.Rename(staleDir, repoDir)
+		lrs.removeCheckoutDir(freshDir)
+		return fmt.Errorf("[CloneRepository] failed to replace checkout: %s", err.Error())
+	}
+	lrs.removeCheckoutDir(staleDir)
+
+	// The clone was opened at the path it was made in.
+	repo, err := git.PlainOpen(repoDir)
+	if err == nil {
+		localRepo.repo = repo
+		localRepo.CommitSHA, err = gitHeadSHA(repo)
+	}
+	if err != nil {
+		return &GitError{Op: "clone", Repo: localRepo.String(), Err: err}
+	}
+
+	if localRepo.sparseDirs != nil {
+		if err := lrs.rewriteSparseCheckout(localRepo); err != nil {
+			return &GitError{Op: "checkout", Repo: localRepo.String(), Err: err}
+		}
+	}
+
+	log.Println("[CloneRepository] Successfully refreshed repo")
+	return lrs.checkDiskQuota(localRepo)
+}
+
+// cloneWithinQuota clones the branch into dir like cloneBranch, measuring dir
+// while the clone runs and cancelling it as soon as it grows over the checkout
+// quota, so an oversized repository is not downloaded in full. The clone may
+// overshoot the quota by what it writes between two measurements; callers
+// still check the finished checkout with checkDiskQuota.
+func (lrs *LocalRepoService) cloneWithinQuota(remote gitRemote, dir, repoBranch string, sparse bool) (*git.Repository, error) {
+	lrs.mu.Lock()
+	maxBytes := lrs.maxCheckoutBytes
+	lrs.mu.Unlock()
+	if maxBytes <= 0 {
+		return remote.cloneBranch(context.Background(), dir, repoBranch, sparse)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var overQuota int64
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		ticker := time.NewTicker(checkoutWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// The directory may not exist yet, or change while it is walked.
+				if usage, err := diskUsage(dir); err == nil && usage > maxBytes {
+					overQuota = usage
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	repo, err := remote.cloneBranch(ctx, dir, repoBranch, sparse)
+	cancel()
+	<-watchDone
+	if overQuota > 0 {
+		return nil, fmt.Errorf("[checkDiskQuota] clone stopped at %d bytes, over the quota of %d bytes", overQuota, maxBytes)
+	}
+
+	return repo, err
+}
+
+// rewriteSparseCheckout writes the sparse directories at the current commit
+// to the worktree, dropping those no longer in the repository. The caller
+// must hold the work lock of the checkout.
+func (lrs *LocalRepoService) rewriteSparseCheckout(localRepo *LocalRepo) error {
+	repoDir := filepath.Join(lrs.workDir, localRepo.BaseDirectory)
+	if err := clearWorktree(repoDir); err != nil {
+		return err
+	}
+
+	tree, err := commitTree(localRepo.repo, localRepo.CommitSHA)
+	if err != nil {
+		return err
+	}
+
+	for dir := range localRepo.sparseDirs {
+		if err := checkoutDir(tree, repoDir, dir); err != nil {
+			if errors.Is(err, object.ErrDirectoryNotFound) {
+				delete(localRepo.sparseDirs, dir)
+				continue
+			}
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (lrs *LocalRepoService) GenerateManifestWithHelm(repoOwner, repoName, repoBranch, chartPath string) (string, error) {
-	repoHash := lrs.generateRepoHash(repoOwner, repoName, repoBranch)
+// checkoutChart adds the chart at chartDir and its local dependencies to a
+// sparse checkout. If that takes the checkout over its quota, they are
+// removed again. The caller must hold the work lock of the checkout.
+func (lrs *LocalRepoService) checkoutChart(localRepo *LocalRepo, chartDir string) error {
+	if localRepo.sparseDirs == nil || dirCovered(localRepo.sparseDirs, chartDir) {
+		return nil
+	}
 
-	if _, ok := lrs.repoCache[repoHash]; !ok {
-		log.Println("[GenerateManifestWithHelm] repo doesnt exist locally")
-		return "", fmt.Errorf("[GenerateManifestWithHelm] repo doesnt exist locally")
+	tree, err := commitTree(localRepo.repo, localRepo.CommitSHA)
+	if err != nil {
+		return &GitError{Op: "checkout", Repo: localRepo.String(), Err: err}
+	}
+
+	repoDir := filepath.Join(lrs.workDir, localRepo.BaseDirectory)
+	added := []string{}
+	for _, dir := range chartDirs(tree, chartDir) {
+		if dirCovered(localRepo.sparseDirs, dir) {
+			continue
+		}
+
+		log.Println("[checkoutChart] Checking out: ", dir)
+		added = append(added, dir)
+		if err = checkoutDir(tree, repoDir, dir); err != nil {
+			err = &GitError{Op: "checkout", Repo: localRepo.String(), Err: err}
+			break
+		}
 	}
 
-	localRepo := lrs.repoCache[repoHash]
-	helmManifest, err := exec.Command("helm3", "template", repoName, fmt.Sprintf("./%s/%s", localRepo.BaseDirectory, chartPath)).Output()
+	if err == nil {
+		for _, dir := range added {
+			localRepo.sparseDirs[dir] = true
+		}
+
+		if err = lrs.checkDiskQuota(localRepo); err == nil {
+			return nil
+		}
+
+		for _, dir := range added {
+			delete(localRepo.sparseDirs, dir)
+		}
+	}
+
+	// Rewrite the checkout rather than removing the added directories, which
+	// may share parents with the ones already checked out.
+	if rewriteErr := lrs.rewriteSparseCheckout(localRepo); rewriteErr != nil {
+		log.Println("[checkoutChart] Failed to restore checkout: ", rewriteErr.Error())
+	}
+	if usageErr := lrs.checkDiskQuota(localRepo); usageErr != nil {
+		log.Println("[checkoutChart] ", usageErr.Error())
+	}
+
+	return err
+}
+
+// checkDiskQuota records the disk used by the checkout, failing if it is over
+// the checkout quota. Idle checkouts are removed, least recently used first,
+// while the work directory is over its quota.
+func (lrs *LocalRepoService) checkDiskQuota(localRepo *LocalRepo) error {
+	usage, err := diskUsage(filepath.Join(lrs.workDir, localRepo.BaseDirectory))
 	if err != nil {
-		return "", fmt.Errorf("[GenerateManifestWithHelm] error generating manifest: %s", err.Error())
+		return fmt.Errorf("[checkDiskQuota] failed to measure checkout: %s", err.Error())
+	}
+
+	lrs.mu.Lock()
+	localRepo.diskUsage = usage
+	if lrs.maxCheckoutBytes > 0 && usage > lrs.maxCheckoutBytes {
+		lrs.mu.Unlock()
+		return fmt.Errorf("[checkDiskQuota] checkout of %s uses %d bytes, over the quota of %d bytes", localRepo.String(), usage, lrs.maxCheckoutBytes)
+	}
+
+	var total int64
+	for _, repo := range lrs.repoCache {
+		total += repo.diskUsage
+	}
+
+	dirsToRemove := make(map[string]string)
+	for lrs.maxWorkDirBytes > 0 && total > lrs.maxWorkDirBytes {
+		var oldestHash string
+		var oldest *LocalRepo
+		for hash, repo := range lrs.repoCache {
+			if repo == localRepo || repo.inUse > 0 {
+				continue
+			}
+			if oldest == nil || repo.LastActionTimestamp.Before(oldest.LastActionTimestamp) {
+				oldestHash, oldest = hash, repo
+			}
+		}
+		if oldest == nil {
+			break
+		}
+
+		log.Println("[checkDiskQuota] Removing idle checkout to free space: ", oldest.String())
+		total -= oldest.diskUsage
+		if removedDir, ok := lrs.evictLocked(oldestHash, oldest); ok {
+			dirsToRemove[oldestHash] = removedDir
+		}
+	}
+	lrs.mu.Unlock()
+
+	lrs.removeCheckouts(dirsToRemove)
+	if lrs.maxWorkDirBytes > 0 && total > lrs.maxWorkDirBytes {
+		return fmt.Errorf("[checkDiskQuota] work directory uses %d bytes, over the quota of %d bytes", total, lrs.maxWorkDirBytes)
+	}
+
+	return nil
+}
+
+func (localRepo *LocalRepo) String() string {
+	return fmt.Sprintf("%s/%s@%s", localRepo.Owner, localRepo.Name, localRepo.Branch)
+}
+
+// acquire marks the held checkout as in use once more and locks it for the
+// caller, who must call release when done. The mark outlives the checkout
+// being released, for a render that is still running.
+func (lrs *LocalRepoService) acquire(checkout *Checkout) (*LocalRepo, func()) {
+	localRepo := checkout.localRepo
+	lrs.mu.Lock()
+	localRepo.inUse++
+	localRepo.LastActionTimestamp = time.Now()
+	lrs.mu.Unlock()
+
+	localRepo.work.Lock()
+	return localRepo, func() {
+		localRepo.work.Unlock()
+		lrs.release(localRepo)
 	}
+}
 
+// release ends an operation marked in use on the checkout.
+func (lrs *LocalRepoService) release(localRepo *LocalRepo) {
+	lrs.mu.Lock()
+	localRepo.inUse--
 	localRepo.LastActionTimestamp = time.Now()
-	localRepo.Manifest = string(helmManifest)
-	log.Println("[GenerateManifestWithHelm] Manifest generated successfully")
-	return string(helmManifest), nil
+	lrs.mu.Unlock()
 }
 
-func (lrs *LocalRepoService) CreateNewBranch(repoOwner, repoName, repoBranch string) error {
-	repoHash := lrs.generateRepoHash(repoOwner, repoName, repoBranch)
+// GenerateManifestWithHelm renders the chart at chartPath of the checkout with
+// helm template. The options must have been validated with
+// CleanHelmRenderOptions.
+func (lrs *LocalRepoService) GenerateManifestWithHelm(checkout *Checkout, chartPath string, options HelmRenderOptions) (string, error) {
+	localRepo, release := lrs.acquire(checkout)
+
+	// A render that timed out may still be reading the chart and writing its
+	// dependencies, so the checkout stays locked until the render stopped.
+	var renderStopped <-chan struct{}
+	defer func() {
+		if renderStopped == nil {
+			release()
+			return
+		}
 
-	if _, ok := lrs.repoCache[repoHash]; !ok {
-		log.Println("[CreateNewBranch] repo doesnt exist locally")
-		return fmt.Errorf("[CreateNewBranch] repo doesnt exist locally")
+		select {
+		case <-renderStopped:
+			release()
+		default:
+			log.Println("[GenerateManifestWithHelm] Keeping checkout locked until the abandoned render stops")
+			go func() {
+				<-renderStopped
+				release()
+			}()
+		}
+	}()
+
+	chartDir, ok := repoRelativeDir(filepath.ToSlash(chartPath))
+	if !ok {
+		return "", fmt.Errorf("[GenerateManifestWithHelm] invalid chart path %s", chartPath)
+	}
+	if path.Base(chartDir) == "Chart.yaml" {
+		chartDir, _ = repoRelativeDir(path.Dir(chartDir))
 	}
 
-	localRepo := lrs.repoCache[repoHash]
-	err := exec.Command("cd", fmt.Sprintf("./%s/", localRepo.BaseDirectory), "&&", "git", "checkout", "-b", localRepo.BaseDirectory).Run()
+	if err := lrs.checkoutChart(localRepo, chartDir); err != nil {
+		return "", fmt.Errorf("[GenerateManifestWithHelm] %s", err.Error())
+	}
+
+	// Values files outside of the chart are checked out with their directory.
+	repoDir := filepath.Join(lrs.workDir, localRepo.BaseDirectory)
+	valuesPaths := make([]string, 0, len(options.ValuesFiles))
+	for _, valuesFile := range options.ValuesFiles {
+		notFound := fmt.Errorf("[GenerateManifestWithHelm] %w", &PathError{Path: valuesFile, Reason: "values file not found", Code: ErrCodeInvalidPath})
+
+		valuesDir, _ := repoRelativeDir(path.Dir(valuesFile))
+		if localRepo.sparseDirs != nil && !dirCovered(localRepo.sparseDirs, valuesDir) {
+			tree, err := commitTree(localRepo.repo, localRepo.CommitSHA)
+			if err == nil {
+				_, err = tree.File(valuesFile)
+			}
+			if err != nil {
+				return "", notFound
+			}
+
+			if err := lrs.checkoutChart(localRepo, valuesDir); err != nil {
+				return "", fmt.Errorf("[GenerateManifestWithHelm] %s", err.Error())
+			}
+		}
+
+		valuesPath, err := checkoutFilePath(repoDir, valuesFile)
+		if err != nil {
+			return "", fmt.Errorf("[GenerateManifestWithHelm] %w", err)
+		}
+		if _, err := os.Stat(valuesPath); err != nil {
+			return "", notFound
+		}
+		valuesPaths = append(valuesPaths, valuesPath)
+	}
+
+	if options.ReleaseName == "" {
+		options.ReleaseName = localRepo.Name
+	}
+
+	manifestKey := localRepo.BaseDirectory + "@" + localRepo.CommitSHA + ":" + options.cacheKey(chartDir)
+	var manifest string
+	if localRepo.CommitSHA != "" && lrs.manifests.Get(manifestKey, &manifest) {
+		log.Println("[GenerateManifestWithHelm] Using manifest rendered at: ", localRepo.CommitSHA)
+		return manifest, nil
+	}
+
+	lrs.mu.Lock()
+	renderer := lrs.helm
+	lrs.mu.Unlock()
+
+	helmManifest, renderStopped, err := renderer.render(filepath.Join(repoDir, filepath.FromSlash(chartDir)), valuesPaths, options)
 	if err != nil {
-		return fmt.Errorf("[CreateNewBranch] error creating new branch: %s", err.Error())
+		return "", fmt.Errorf("[GenerateManifestWithHelm] error generating manifest: %w", err)
 	}
 
-	log.Println("[CreateNewBranch] branch created successfully")
-	return nil
+	lrs.manifests.Put(manifestKey, helmManifest)
+	log.Println("[GenerateManifestWithHelm] Manifest generated successfully")
+	return helmManifest, nil
 }
 
-func (lrs *LocalRepoService) SaveFile(repoOwner, repoName, repoBranch, fileContents, fileName string) (string, error) {
-	repoHash := lrs.generateRepoHash(repoOwner, repoName, repoBranch)
+// GenerateManifestWithKustomize builds the kustomization at kustomizationPath
+// of the checkout, its directory or kustomization file. It is built in memory
+// from the commit of the checkout, so it works on sparse checkouts as well.
+func (lrs *LocalRepoService) GenerateManifestWithKustomize(checkout *Checkout, kustomizationPath string) (string, error) {
+	localRepo, release := lrs.acquire(checkout)
+	defer release()
+
+	kustomizeDir, ok := repoRelativeDir(filepath.ToSlash(kustomizationPath))
+	if !ok {
+		return "", fmt.Errorf("[GenerateManifestWithKustomize] %w", &PathError{Path: kustomizationPath, Reason: "path leaves the repository", Code: ErrCodeInvalidPath})
+	}
+	if IsKustomizationFile(kustomizeDir) {
+		kustomizeDir, _ = repoRelativeDir(path.Dir(kustomizeDir))
+	}
 
-	if _, ok := lrs.repoCache[repoHash]; !ok {
-		log.Println("[SaveFile] repo doesnt exist locally")
-		return "", fmt.Errorf("[SaveFile] repo doesnt exist locally")
+	manifestKey := localRepo.BaseDirectory + "@" + localRepo.CommitSHA + ":kustomize:" + kustomizeDir
+	var manifest string
+	if lrs.manifests.Get(manifestKey, &manifest) {
+		log.Println("[GenerateManifestWithKustomize] Using manifest built at: ", localRepo.CommitSHA)
+		return manifest, nil
 	}
 
-	localRepo := lrs.repoCache[repoHash]
-	err := os.WriteFile(fmt.Sprintf("./%s/%s", localRepo.BaseDirectory, fileName), []byte(fileContents), 0755)
+	tree, err := commitTree(localRepo.repo, localRepo.CommitSHA)
 	if err != nil {
-		log.Println("[SaveFile] failed to save file")
-		return "", fmt.Errorf("[SaveFile] failed to save file: %s", err.Error())
+		return "", &GitError{Op: "read tree", Repo: localRepo.String(), Err: err}
 	}
 
-	return fmt.Sprintf("./%s/%s", localRepo.BaseDirectory, fileName), nil
+	manifest, err = renderKustomization(tree, kustomizeDir)
+	if err != nil {
+		return "", fmt.Errorf("[GenerateManifestWithKustomize] %w", err)
+	}
+
+	lrs.manifests.Put(manifestKey, manifest)
+	log.Println("[GenerateManifestWithKustomize] Manifest built successfully")
+	return manifest, nil
 }
 
+// generateRepoHash names the checkout directory of a branch. The name is hex
+// encoded, so it is safe to use as a path and never looks like an option.
 func (lrs *LocalRepoService) generateRepoHash(repoOwner, repoName, repoBranch string) string {
-	repoInfo := []byte(repoOwner + repoName + repoBranch)
 	hash := sha1.New()
-	hash.Write(repoInfo)
-	return base64.URLEncoding.EncodeToString(hash.Sum(nil))
+	hash.Write([]byte(repoOwner + "\x00" + repoName + "\x00" + repoBranch))
+	return checkoutDirPrefix + hex.EncodeToString(hash.Sum(nil))
 }
 
+// cleanupCache removes the checkouts unused for longer than the cache TTL.
+// Checkouts being cloned or worked in are skipped, and a removed checkout is
+// first renamed so a new clone of the same branch cannot collide with it.
 func (lrs *LocalRepoService) cleanupCache() {
 	log.Println("[cleanupCache] cleaning up unused repos")
-	hashesToRemove := make([]string, 0)
+	lrs.mu.Lock()
+	dirsToRemove := make(map[string]string)
 	for hash, repo := range lrs.repoCache {
-		if time.Now().Before(repo.LastActionTimestamp.Add(10 * time.Minute)) {
+		if repo.inUse > 0 || time.Now().Before(repo.LastActionTimestamp.Add(lrs.cacheTTL)) {
 			continue
 		}
 
-		hashesToRemove = append(hashesToRemove, hash)
+		if removedDir, ok := lrs.evictLocked(hash, repo); ok {
+			dirsToRemove[hash] = removedDir
+		}
 	}
+	lrs.mu.Unlock()
 
-	log.Println("[cleanupCache] Repos to cleanup: ", len(hashesToRemove))
-	var err error
-	for _, hash := range hashesToRemove {
-		repo := lrs.repoCache[hash]
-		err = exec.Command("rm", "-rf", fmt.Sprintf("./%s/", repo.BaseDirectory)).Run()
-		if err != nil {
+	log.Println("[cleanupCache] Repos to cleanup: ", len(dirsToRemove))
+	lrs.removeCheckouts(dirsToRemove)
+}
+
+// evictLocked drops the checkout from the cache and renames its directory,
+// returning the new name for the caller to remove once the service lock is
+// released. The caller must hold the service lock.
+func (lrs *LocalRepoService) evictLocked(hash string, repo *LocalRepo) (string, bool) {
+	repoDir := filepath.Join(lrs.workDir, repo.BaseDirectory)
+	removedDir := fmt.Sprintf("%s.removing-%d", repoDir, time.Now().UnixNano())
+	if err := os.Rename(repoDir, removedDir); err != nil {
+		if os.IsNotExist(err) {
+			delete(lrs.repoCache, hash)
+			return "", false
+		}
+		log.Println("[cleanupCache] Failed to cleanup repo with hash: ", hash)
+		return "", false
+	}
+
+	delete(lrs.repoCache, hash)
+	return removedDir, true
+}
+
+// removeCheckouts removes the directories of evicted checkouts, keyed by the
+// hash of the checkout.
+func (lrs *LocalRepoService) removeCheckouts(dirsToRemove map[string]string) {
+	for hash, dir := range dirsToRemove {
+		if err := lrs.removeCheckoutDir(dir); err != nil {
 			log.Println("[cleanupCache] Failed to cleanup repo with hash: ", hash)
 			continue
 		}
 
-		delete(lrs.repoCache, hash)
 		log.Println("[cleanupCache] Succeesful repo cleanup: ", hash)
 	}
 }
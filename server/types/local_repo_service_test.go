@@ -0,0 +1,376 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	fixtureOwner  = "owner"
+	fixtureRepo   = "repo"
+	fixtureBranch = "master"
+)
+
+var fixtureFiles = map[string]string{
+	"app/Chart.yaml":             "apiVersion: v2\nname: app\nversion: 0.1.0\n",
+	"app/values.yaml":            "port: 80\n",
+	"app/templates/service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: {{ .Release.Name }}\nspec:\n  ports:\n  - port: {{ .Values.port }}\n",
+}
+
+// newFixtureRepo commits fixtureFiles to a new repository and returns its
+// file:// clone URL and the SHA of the commit.
+func newFixtureRepo(t *testing.T) (string, string) {
+	t.Helper()
+	// go-git clones file:// URLs with git-upload-pack.
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filePath, contents := range fixtureFiles {
+		fullPath := filepath.Join(dir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Add(filePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commit, err := worktree.Commit("fixture", &git.CommitOptions{
+		Author: &object.Signature{Name: commitName, Email: commitEmail, When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "file://" + dir, commit.String()
+}
+
+func newTestRepoService(t *testing.T, cacheTTL time.Duration) *LocalRepoService {
+	t.Helper()
+	lrs, err := NewLocalRepoService(t.TempDir(), cacheTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lrs
+}
+
+// checkoutDirs returns the checkouts left in the work directory, including
+// those being replaced or removed.
+func checkoutDirs(t *testing.T, lrs *LocalRepoService) []string {
+	t.Helper()
+	entries, err := os.ReadDir(lrs.workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirs := []string{}
+	for _, entry := range entries {
+		if checkoutDirPattern.MatchString(entry.Name()) {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+
+	return dirs
+}
+
+func TestConcurrentCloneAndRender(t *testing.T) {
+	cloneURL, headSHA := newFixtureRepo(t)
+	lrs := newTestRepoService(t, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkout, err := lrs.CloneRepositoryLocaly(cloneURL, fixtureOwner, fixtureRepo, fixtureBranch, headSHA)
+			if err != nil {
+				t.Errorf("clone: %v", err)
+				return
+			}
+			defer checkout.Release()
+
+			manifest, err := lrs.GenerateManifestWithHelm(checkout, "app", HelmRenderOptions{})
+			if err != nil {
+				t.Errorf("render: %v", err)
+				return
+			}
+			if !strings.Contains(manifest, "name: "+fixtureRepo) {
+				t.Errorf("manifest does not hold the service:\n%s", manifest)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if dirs := checkoutDirs(t, lrs); len(dirs) != 1 {
+		t.Errorf("expected the branch to be cloned once, found checkouts %v", dirs)
+	}
+}
+
+func TestConcurrentCloneRenderAndCleanup(t *testing.T) {
+	cloneURL, headSHA := newFixtureRepo(t)
+	// Every checkout not in use is evicted by cleanup.
+	lrs := newTestRepoService(t, 0)
+
+	done := make(chan struct{})
+	cleanupDone := make(chan struct{})
+	go func() {
+		defer close(cleanupDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				lrs.cleanupCache()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				checkout, err := lrs.CloneRepositoryLocaly(cloneURL, fixtureOwner, fixtureRepo, fixtureBranch, headSHA)
+				if err != nil {
+					t.Errorf("clone: %v", err)
+					return
+				}
+
+				// The checkout is held from the clone to the render, so cleanup
+				// cannot evict it in between.
+				manifest, err := lrs.GenerateManifestWithHelm(checkout, "app", HelmRenderOptions{})
+				checkout.Release()
+				if err != nil {
+					t.Errorf("render: %v", err)
+					continue
+				}
+				if !strings.Contains(manifest, "name: "+fixtureRepo) {
+					t.Errorf("manifest does not hold the service:\n%s", manifest)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-cleanupDone
+
+	lrs.cleanupCache()
+	if dirs := checkoutDirs(t, lrs); len(dirs) != 0 {
+		t.Errorf("expected cleanup to remove every checkout, found %v", dirs)
+	}
+}
+
+// TestCloneWaiterSurvivesCleanup checks that a request waiting for a clone in
+// flight keeps the checkout from being evicted once the clone finishes.
+func TestCloneWaiterSurvivesCleanup(t *testing.T) {
+	cloneURL, headSHA := newFixtureRepo(t)
+	lrs := newTestRepoService(t, 0)
+
+	// Stand in for a clone in flight, the way CloneRepositoryLocaly registers
+	// one. LastActionTimestamp is left zero to see when the waiter arrives.
+	repoHash := lrs.generateRepoHash(fixtureOwner, fixtureRepo, fixtureBranch)
+	localRepo := &LocalRepo{
+		Name:           fixtureRepo,
+		Owner:          fixtureOwner,
+		Branch:         fixtureBranch,
+		BaseDirectory:  repoHash,
+		CloneTimestamp: time.Now(),
+		ready:          make(chan struct{}),
+		inUse:          1,
+	}
+	lrs.mu.Lock()
+	lrs.repoCache[repoHash] = localRepo
+	lrs.mu.Unlock()
+
+	waiterErr := make(chan error, 1)
+	waiterCheckout := make(chan *Checkout, 1)
+	go func() {
+		checkout, err := lrs.CloneRepositoryLocaly(cloneURL, fixtureOwner, fixtureRepo, fixtureBranch, headSHA)
+		waiterCheckout <- checkout
+		waiterErr <- err
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		lrs.mu.Lock()
+		waiting := !localRepo.LastActionTimestamp.IsZero()
+		lrs.mu.Unlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("waiter did not find the clone in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	repo, err := lrs.newGitRemote(cloneURL).cloneBranch(context.Background(), filepath.Join(lrs.workDir, repoHash), fixtureBranch, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localRepo.repo = repo
+	localRepo.CommitSHA = headSHA
+
+	// Finish the clone, then run cleanup before the waiter wakes up.
+	lrs.mu.Lock()
+	localRepo.inUse--
+	lrs.mu.Unlock()
+	lrs.cleanupCache()
+	close(localRepo.ready)
+
+	checkout := <-waiterCheckout
+	if err := <-waiterErr; err != nil {
+		t.Fatalf("waiter: %v", err)
+	}
+	defer checkout.Release()
+
+	// Cleanup must not evict the checkout handed to the waiter either.
+	lrs.cleanupCache()
+	if _, err := lrs.GenerateManifestWithHelm(checkout, "app", HelmRenderOptions{}); err != nil {
+		t.Fatalf("render after waiting: %v", err)
+	}
+}
+
+func TestCloneOverQuota(t *testing.T) {
+	cloneURL, headSHA := newFixtureRepo(t)
+	lrs := newTestRepoService(t, time.Hour)
+	lrs.ConfigureCheckouts(false, 1, 0)
+
+	_, err := lrs.CloneRepositoryLocaly(cloneURL, fixtureOwner, fixtureRepo, fixtureBranch, headSHA)
+	if err == nil || !strings.Contains(err.Error(), "over the quota") {
+		t.Fatalf("expected the clone to fail over the quota, got %v", err)
+	}
+
+	if dirs := checkoutDirs(t, lrs); len(dirs) != 0 {
+		t.Errorf("expected the clone to be removed, found %v", dirs)
+	}
+}
+
+// TestConcurrentRenderAndQuotaEviction clones several branches at once into a
+// work directory with room for about one checkout, so every clone evicts the
+// idle checkouts of the others, while cleanup runs as well. A checkout held
+// from its clone to its render must never be evicted under it.
+func TestConcurrentRenderAndQuotaEviction(t *testing.T) {
+	cloneURL, headSHA := newFixtureRepo(t)
+	lrs := newTestRepoService(t, 0)
+
+	checkout, err := lrs.CloneRepositoryLocaly(cloneURL, fixtureOwner, fixtureRepo, fixtureBranch, headSHA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lrs.mu.Lock()
+	checkoutBytes := checkout.localRepo.diskUsage
+	lrs.mu.Unlock()
+	checkout.Release()
+	lrs.ConfigureCheckouts(false, 0, checkoutBytes*3/2)
+
+	done := make(chan struct{})
+	cleanupDone := make(chan struct{})
+	go func() {
+		defer close(cleanupDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				lrs.cleanupCache()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		owner := fmt.Sprintf("owner-%d", i%3)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				checkout, err := lrs.CloneRepositoryLocaly(cloneURL, owner, fixtureRepo, fixtureBranch, headSHA)
+				if err != nil {
+					// Checkouts held by the other goroutines cannot be evicted,
+					// so the work directory may stay over its quota.
+					if !strings.Contains(err.Error(), "over the quota") {
+						t.Errorf("clone: %v", err)
+					}
+					continue
+				}
+
+				manifest, err := lrs.GenerateManifestWithHelm(checkout, "app", HelmRenderOptions{})
+				checkout.Release()
+				if err != nil {
+					t.Errorf("render: %v", err)
+					continue
+				}
+				if !strings.Contains(manifest, "name: "+fixtureRepo) {
+					t.Errorf("manifest does not hold the service:\n%s", manifest)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-cleanupDone
+}
+
+func TestRenderedManifestsBounded(t *testing.T) {
+	cloneURL, headSHA := newFixtureRepo(t)
+	lrs := newTestRepoService(t, time.Hour)
+
+	checkout, err := lrs.CloneRepositoryLocaly(cloneURL, fixtureOwner, fixtureRepo, fixtureBranch, headSHA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkout.Release()
+
+	// Room for a few renders, so every distinct --set evicts an older one.
+	lrs.manifests, err = NewObjectCache(1024, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		options := HelmRenderOptions{SetValues: []string{fmt.Sprintf("port=%d", 8000+i)}}
+		if _, err := lrs.GenerateManifestWithHelm(checkout, "app", options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := HelmRenderOptions{SetValues: []string{"port=8019"}}
+	if _, err := lrs.GenerateManifestWithHelm(checkout, "app", options); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := lrs.manifests.Stats()
+	if stats.Bytes > 1024 || stats.Evictions == 0 {
+		t.Errorf("expected the rendered manifests to stay within the bound, got %+v", stats)
+	}
+	if stats.Hits != 1 {
+		t.Errorf("expected the last render to be served from the cache, got %+v", stats)
+	}
+}
@@ -0,0 +1,85 @@
+package types
+
+import (
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redactedSecret = "[REDACTED]"
+
+// minRedactedSecretLength keeps short values, which would mask unrelated log
+// text, from being registered as secrets.
+const minRedactedSecretLength = 8
+
+var (
+	// tokenPattern matches the prefixed token formats of GitHub and GitLab,
+	// which covers tokens minted at runtime such as installation and OAuth
+	// tokens.
+	tokenPattern = regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,}|glpat-[A-Za-z0-9_-]{20,})`)
+	// urlCredentialsPattern matches the password of credentials embedded in a
+	// URL.
+	urlCredentialsPattern = regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`)
+)
+
+var logRedactor = &redactingWriter{secrets: make(map[string]struct{})}
+
+// redactingWriter masks registered secrets, known token formats and URL
+// credentials before passing log output on.
+type redactingWriter struct {
+	mu      sync.RWMutex
+	out     io.Writer
+	secrets map[string]struct{}
+}
+
+// ConfigureLogRedaction routes the standard logger through the redactor,
+// writing the masked output to out.
+func ConfigureLogRedaction(out io.Writer) {
+	logRedactor.mu.Lock()
+	logRedactor.out = out
+	logRedactor.mu.Unlock()
+
+	log.SetOutput(logRedactor)
+}
+
+// RedactSecret masks secret wherever it appears in log output.
+func RedactSecret(secret string) {
+	if len(secret) < minRedactedSecretLength {
+		return
+	}
+
+	logRedactor.mu.Lock()
+	defer logRedactor.mu.Unlock()
+	logRedactor.secrets[secret] = struct{}{}
+}
+
+// Redact returns s with the secrets known to the log redactor masked, for
+// text that leaves the process some other way, such as git output.
+func Redact(s string) string {
+	logRedactor.mu.RLock()
+	defer logRedactor.mu.RUnlock()
+	return logRedactor.redact(s)
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	rw.mu.RLock()
+	defer rw.mu.RUnlock()
+
+	if _, err := io.WriteString(rw.out, rw.redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// redact must be called with the lock held.
+func (rw *redactingWriter) redact(s string) string {
+	for secret := range rw.secrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+	}
+
+	s = tokenPattern.ReplaceAllString(s, redactedSecret)
+	return urlCredentialsPattern.ReplaceAllString(s, "${1}"+redactedSecret+"@")
+}
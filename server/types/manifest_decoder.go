@@ -0,0 +1,205 @@
+package types
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// sourceHeaderPattern matches the "# Source: <file>" comment Helm writes at
+// the top of each document it renders.
+var sourceHeaderPattern = regexp.MustCompile(`^#\s*Source:\s*(\S.*?)\s*$`)
+
+// ManifestObject is a Kubernetes object decoded from a manifest.
+type ManifestObject struct {
+	// Source is the file the object was read from: the path relative to the
+	// root of the repository, or the template Helm named in the document.
+	Source string
+	Object runtime.Object
+}
+
+// ManifestDecodeError reports a document of a manifest that could not be
+// decoded. Documents are numbered from 1.
+type ManifestDecodeError struct {
+	Source   string
+	Document int
+	Err      error
+}
+
+func (e *ManifestDecodeError) Error() string {
+	return fmt.Sprintf("%s: document %d: %s", e.Source, e.Document, e.Err.Error())
+}
+
+func (e *ManifestDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// IsManifestFile reports whether the file at filePath is a YAML or JSON file,
+// which may hold Kubernetes manifests.
+func IsManifestFile(filePath string) bool {
+	switch path.Ext(filePath) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+
+	return false
+}
+
+// DecodeManifests decodes the objects in manifest, read from source: a stream
+// of YAML documents separated by "---" lines, or of JSON objects. Lists are
+// expanded into their items, kinds unknown to client-go are decoded as
+// unstructured objects, and empty or comment-only documents are skipped. A
+// document that cannot be decoded is reported in the returned errors without
+// failing the others.
+func DecodeManifests(source string, manifest []byte) ([]*ManifestObject, []error) {
+	objects := make([]*ManifestObject, 0)
+	var errs []error
+
+	reader := yamlutil.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifest)))
+	for document := 1; ; document++ {
+		data, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, &ManifestDecodeError{Source: source, Document: document, Err: err})
+			break
+		}
+
+		documentSource := sourceHeader(data, source)
+		values, err := documentValues(data)
+		if err != nil {
+			errs = append(errs, &ManifestDecodeError{Source: documentSource, Document: document, Err: err})
+			continue
+		}
+
+		for _, value := range values {
+			decoded, err := decodeManifestObject(value)
+			if err != nil {
+				errs = append(errs, &ManifestDecodeError{Source: documentSource, Document: document, Err: err})
+				continue
+			}
+
+			for _, obj := range decoded {
+				objects = append(objects, &ManifestObject{Source: documentSource, Object: obj})
+			}
+		}
+	}
+
+	return objects, errs
+}
+
+// sourceHeader returns the file named by a Helm "# Source:" comment in the
+// leading comments of document, or source if there is none.
+func sourceHeader(document []byte, source string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(document))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || bytes.HasPrefix(line, []byte("---")) {
+			continue
+		}
+		if line[0] != '#' {
+			break
+		}
+
+		if match := sourceHeaderPattern.FindSubmatch(line); match != nil {
+			return string(match[1])
+		}
+	}
+
+	return source
+}
+
+// documentValues converts document to the JSON values it holds: one for a
+// YAML document, any number for a stream of JSON objects. An empty document
+// holds none.
+func documentValues(document []byte) ([][]byte, error) {
+	if trimmed := bytes.TrimSpace(document); len(trimmed) > 0 && trimmed[0] == '{' {
+		if values, err := jsonValues(trimmed); err == nil {
+			return values, nil
+		}
+		// Not JSON after all, but a YAML flow mapping.
+	}
+
+	data, err := yaml.YAMLToJSON(document)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, nil
+	}
+
+	return [][]byte{data}, nil
+}
+
+func jsonValues(data []byte) ([][]byte, error) {
+	var values [][]byte
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			return values, nil
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+// decodeManifestObject decodes the JSON object in data, returning the items
+// of a list rather than the list itself.
+func decodeManifestObject(data []byte) ([]runtime.Object, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		unstructuredObj := &unstructured.Unstructured{}
+		if err := unstructuredObj.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		obj = unstructuredObj
+
+		if unstructuredObj.IsList() {
+			if obj, err = unstructuredObj.ToList(); err != nil {
+				return nil, err
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !meta.IsListType(obj) {
+		return []runtime.Object{obj}, nil
+	}
+
+	items, err := meta.ExtractList(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]runtime.Object, 0, len(items))
+	for _, item := range items {
+		// The items of a v1 List are left undecoded.
+		if unknown, ok := item.(*runtime.Unknown); ok {
+			decoded, err := decodeManifestObject(unknown.Raw)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, decoded...)
+			continue
+		}
+		objects = append(objects, item)
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,127 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ManifestSelector selects the manifest files of a manifests directory.
+type ManifestSelector struct {
+	// Dir is relative to the root of the repository, "" for the root.
+	Dir string
+	// Recursive selects the files of the subdirectories of Dir as well.
+	Recursive bool
+	// Include and Exclude are glob patterns, in the syntax of path.Match. A
+	// pattern with a slash is matched against the path of a file relative to
+	// Dir, one without against its name. When Include is empty every file is
+	// included; Exclude wins over Include.
+	Include []string
+	Exclude []string
+}
+
+// CleanManifestSelector validates selector and returns it with its directory
+// cleaned.
+func CleanManifestSelector(selector ManifestSelector) (ManifestSelector, error) {
+	dir, err := CleanRepoDir(selector.Dir)
+	if err != nil {
+		return ManifestSelector{}, err
+	}
+
+	for _, pattern := range append(append([]string{}, selector.Include...), selector.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return ManifestSelector{}, fmt.Errorf("invalid glob pattern %q", pattern)
+		}
+	}
+
+	return ManifestSelector{
+		Dir:       dir,
+		Recursive: selector.Recursive,
+		Include:   selector.Include,
+		Exclude:   selector.Exclude,
+	}, nil
+}
+
+// Matches reports whether the manifest file at filePath, relative to the root
+// of the repository, is selected.
+func (s ManifestSelector) Matches(filePath string) bool {
+	if !IsManifestFile(filePath) {
+		return false
+	}
+
+	relPath := filePath
+	if s.Dir != "" {
+		if !strings.HasPrefix(filePath, s.Dir+"/") {
+			return false
+		}
+		relPath = strings.TrimPrefix(filePath, s.Dir+"/")
+	}
+
+	if !s.Recursive && strings.Contains(relPath, "/") {
+		return false
+	}
+
+	if len(s.Include) > 0 && !matchesAny(s.Include, relPath) {
+		return false
+	}
+
+	return !matchesAny(s.Exclude, relPath)
+}
+
+func matchesAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		name := path.Base(relPath)
+		if strings.Contains(pattern, "/") {
+			name = relPath
+		}
+
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsChartTemplate reports whether the file at filePath belongs to the
+// templates or the packaged dependencies of one of the charts in chartDirs,
+// whose files are not plain manifests.
+func IsChartTemplate(filePath string, chartDirs map[string]bool) bool {
+	for dir := path.Dir(filePath); ; dir = path.Dir(dir) {
+		switch path.Base(dir) {
+		case "templates", "charts":
+			if chartDirs[path.Dir(dir)] {
+				return true
+			}
+		}
+
+		if dir == "." || dir == "/" {
+			return false
+		}
+	}
+}
+
+// manifestBlobKey is the object cache key of the classification of a blob.
+func manifestBlobKey(blobSHA string) string {
+	return "manifest-blob\x00" + blobSHA
+}
+
+// CachedManifestBlob reports whether the blob blobSHA was found to decode to
+// at least one Kubernetes object, and whether it was classified at all. Blobs
+// are named by their contents, so the answer holds in every repository.
+func CachedManifestBlob(blobSHA string) (holdsObjects bool, known bool) {
+	if !isObjectSHA(blobSHA) {
+		return false, false
+	}
+
+	known = objectCache.Get(manifestBlobKey(blobSHA), &holdsObjects)
+	return holdsObjects, known
+}
+
+// CacheManifestBlob records whether the blob blobSHA decodes to at least one
+// Kubernetes object.
+func CacheManifestBlob(blobSHA string, holdsObjects bool) {
+	if isObjectSHA(blobSHA) {
+		objectCache.Put(manifestBlobKey(blobSHA), holdsObjects)
+	}
+}
@@ -0,0 +1,375 @@
+package types
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v38/github"
+)
+
+// objectCache is shared by every GitHub client, nil disables caching.
+var objectCache *ObjectCache
+
+var objectSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// ConfigureObjectCache enables caching of git trees and blobs, keeping up to
+// maxBytes of them in memory. When dir is set entries are also written there,
+// up to diskMaxBytes of them, and survive restarts. A maxBytes of zero
+// disables the cache, a diskMaxBytes of zero leaves the directory unbounded.
+func ConfigureObjectCache(maxBytes int64, dir string, diskMaxBytes int64) error {
+	if maxBytes <= 0 {
+		objectCache = nil
+		return nil
+	}
+
+	cache, err := NewObjectCache(maxBytes, dir, diskMaxBytes)
+	if err != nil {
+		return err
+	}
+
+	objectCache = cache
+	return nil
+}
+
+// GetObjectCacheStats returns the statistics of the shared object cache, and
+// false when caching is disabled.
+func GetObjectCacheStats() (CacheStats, bool) {
+	if objectCache == nil {
+		return CacheStats{}, false
+	}
+
+	return objectCache.Stats(), true
+}
+
+type CacheStats struct {
+	Hits          int64 `json:"hits"`
+	DiskHits      int64 `json:"diskHits"`
+	Misses        int64 `json:"misses"`
+	NotModified   int64 `json:"notModified"`
+	Evictions     int64 `json:"evictions"`
+	Entries       int   `json:"entries"`
+	Bytes         int64 `json:"bytes"`
+	MaxBytes      int64 `json:"maxBytes"`
+	DiskEvictions int64 `json:"diskEvictions"`
+	DiskEntries   int   `json:"diskEntries"`
+	DiskBytes     int64 `json:"diskBytes"`
+	DiskMaxBytes  int64 `json:"diskMaxBytes"`
+}
+
+// ObjectCache is a least recently used cache of JSON encoded values, bounded
+// by their encoded size. Values are decoded into a fresh copy on every hit so
+// callers can modify what they get back. The files of the disk tier are
+// bounded the same way, separately.
+type ObjectCache struct {
+	mu           sync.Mutex
+	maxBytes     int64
+	dir          string
+	diskMaxBytes int64
+	entries      map[string]*list.Element
+	order        *list.List
+	diskFiles    map[string]*list.Element
+	diskOrder    *list.List
+	stats        CacheStats
+}
+
+type cacheEntry struct {
+	key   string
+	value []byte
+}
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+func NewObjectCache(maxBytes int64, dir string, diskMaxBytes int64) (*ObjectCache, error) {
+	oc := &ObjectCache{
+		maxBytes:     maxBytes,
+		dir:          dir,
+		diskMaxBytes: diskMaxBytes,
+		entries:      make(map[string]*list.Element),
+		order:        list.New(),
+		diskFiles:    make(map[string]*list.Element),
+		diskOrder:    list.New(),
+		stats:        CacheStats{MaxBytes: maxBytes, DiskMaxBytes: diskMaxBytes},
+	}
+
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("[NewObjectCache] failed to create cache directory: %s", err.Error())
+		}
+		if err := oc.loadDiskFiles(); err != nil {
+			return nil, fmt.Errorf("[NewObjectCache] failed to read cache directory: %s", err.Error())
+		}
+	}
+
+	return oc, nil
+}
+
+// loadDiskFiles indexes the files left in the cache directory by an earlier
+// run, least recently used first going by their modification time, and prunes
+// them down to the disk bound.
+func (oc *ObjectCache) loadDiskFiles() error {
+	infos, err := ioutil.ReadDir(oc.dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".json") {
+			oc.diskFiles[info.Name()] = oc.diskOrder.PushFront(&diskEntry{name: info.Name(), size: info.Size()})
+			oc.stats.DiskBytes += info.Size()
+		}
+	}
+
+	oc.pruneDiskLocked()
+	return nil
+}
+
+// Get decodes the value cached under key into v and reports whether it was
+// found. A nil cache never finds anything.
+func (oc *ObjectCache) Get(key string, v interface{}) bool {
+	if oc == nil {
+		return false
+	}
+
+	oc.mu.Lock()
+	value, ok := oc.getLocked(key)
+	oc.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if err := json.Unmarshal(value, v); err != nil {
+		log.Println("[ObjectCache] failed to decode cached value: ", err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (oc *ObjectCache) getLocked(key string) ([]byte, bool) {
+	if element, ok := oc.entries[key]; ok {
+		oc.order.MoveToFront(element)
+		if diskElement, ok := oc.diskFiles[diskName(key)]; ok {
+			oc.diskOrder.MoveToFront(diskElement)
+		}
+		oc.stats.Hits++
+		return element.Value.(*cacheEntry).value, true
+	}
+
+	if element, ok := oc.diskFiles[diskName(key)]; ok {
+		path := filepath.Join(oc.dir, diskName(key))
+		if value, err := ioutil.ReadFile(path); err == nil {
+			// The modification time orders the files again after a restart.
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			oc.diskOrder.MoveToFront(element)
+			oc.stats.DiskHits++
+			oc.addLocked(key, value)
+			return value, true
+		}
+		oc.removeDiskLocked(element)
+	}
+
+	oc.stats.Misses++
+	return nil, false
+}
+
+// Put caches v under key, evicting the least recently used entries to stay
+// within the size bound.
+func (oc *ObjectCache) Put(key string, v interface{}) {
+	if oc == nil {
+		return
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		log.Println("[ObjectCache] failed to encode value: ", err.Error())
+		return
+	}
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	oc.addLocked(key, value)
+	if oc.dir != "" {
+		oc.writeDiskLocked(key, value)
+	}
+}
+
+// writeDiskLocked writes value to the cache directory, removing the least
+// recently used files to stay within the disk bound.
+func (oc *ObjectCache) writeDiskLocked(key string, value []byte) {
+	if oc.diskMaxBytes > 0 && int64(len(value)) > oc.diskMaxBytes {
+		return
+	}
+
+	name := diskName(key)
+	if element, ok := oc.diskFiles[name]; ok {
+		oc.stats.DiskBytes -= element.Value.(*diskEntry).size
+		oc.diskOrder.Remove(element)
+		delete(oc.diskFiles, name)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(oc.dir, name), value, 0o600); err != nil {
+		log.Println("[ObjectCache] failed to write cache file: ", err.Error())
+		return
+	}
+
+	oc.diskFiles[name] = oc.diskOrder.PushFront(&diskEntry{name: name, size: int64(len(value))})
+	oc.stats.DiskBytes += int64(len(value))
+	oc.pruneDiskLocked()
+}
+
+func (oc *ObjectCache) pruneDiskLocked() {
+	for oc.diskMaxBytes > 0 && oc.stats.DiskBytes > oc.diskMaxBytes {
+		oc.removeDiskLocked(oc.diskOrder.Back())
+		oc.stats.DiskEvictions++
+	}
+}
+
+func (oc *ObjectCache) removeDiskLocked(element *list.Element) {
+	entry := element.Value.(*diskEntry)
+	if err := os.Remove(filepath.Join(oc.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+		log.Println("[ObjectCache] failed to remove cache file: ", err.Error())
+	}
+
+	oc.diskOrder.Remove(element)
+	delete(oc.diskFiles, entry.name)
+	oc.stats.DiskBytes -= entry.size
+}
+
+func (oc *ObjectCache) addLocked(key string, value []byte) {
+	if int64(len(value)) > oc.maxBytes {
+		return
+	}
+
+	if element, ok := oc.entries[key]; ok {
+		oc.stats.Bytes -= int64(len(element.Value.(*cacheEntry).value))
+		oc.order.Remove(element)
+		delete(oc.entries, key)
+	}
+
+	oc.entries[key] = oc.order.PushFront(&cacheEntry{key: key, value: value})
+	oc.stats.Bytes += int64(len(value))
+
+	for oc.stats.Bytes > oc.maxBytes {
+		oldest := oc.order.Back()
+		entry := oldest.Value.(*cacheEntry)
+		oc.order.Remove(oldest)
+		delete(oc.entries, entry.key)
+		oc.stats.Bytes -= int64(len(entry.value))
+		oc.stats.Evictions++
+	}
+}
+
+func (oc *ObjectCache) recordNotModified() {
+	if oc == nil {
+		return
+	}
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	oc.stats.NotModified++
+}
+
+func (oc *ObjectCache) Stats() CacheStats {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	stats := oc.stats
+	stats.Entries = len(oc.entries)
+	stats.DiskEntries = len(oc.diskFiles)
+	return stats
+}
+
+// diskName returns the name of the file key is cached in.
+func diskName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+// isObjectSHA reports whether ref is a full object SHA rather than a branch
+// name, only those are immutable and safe to cache.
+func isObjectSHA(ref string) bool {
+	return objectSHAPattern.MatchString(ref)
+}
+
+// conditionalEntry is a cached response body with the validator used to check
+// it is still current.
+type conditionalEntry struct {
+	ETag     string          `json:"etag"`
+	NextPage int             `json:"nextPage"`
+	LastPage int             `json:"lastPage"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// cacheKey scopes cached entries to the host and, for a user client, to the
+// user, so nobody is served content they could not fetch themselves.
+func (ghc *GitHubClient) cacheKey(parts ...string) string {
+	key := ghc.cloneHost
+	if ghc.userLogin != "" {
+		key += "@" + ghc.userLogin
+	}
+
+	for _, part := range parts {
+		key += "/" + part
+	}
+
+	return key
+}
+
+// conditionalGet fetches the API path u into v, revalidating a cached copy
+// with If-None-Match. GitHub does not count 304 Not Modified answers against
+// the rate limit. The returned response keeps its 304 status code.
+func (ghc *GitHubClient) conditionalGet(ctx context.Context, u string, v interface{}) (*github.Response, error) {
+	key := ghc.cacheKey("etag", u)
+	cached := conditionalEntry{}
+	haveCached := objectCache.Get(key, &cached)
+
+	return ghc.withRetry(ctx, func() (*github.Response, error) {
+		req, err := ghc.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if haveCached {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+
+		resp, err := ghc.Do(ctx, req, v)
+		if haveCached && resp != nil && resp.StatusCode == http.StatusNotModified {
+			objectCache.recordNotModified()
+			resp.NextPage = cached.NextPage
+			resp.LastPage = cached.LastPage
+			return resp, json.Unmarshal(cached.Body, v)
+		}
+		if err != nil {
+			return resp, err
+		}
+
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			body, err := json.Marshal(v)
+			if err == nil {
+				objectCache.Put(key, conditionalEntry{ETag: etag, NextPage: resp.NextPage, LastPage: resp.LastPage, Body: body})
+			}
+		}
+
+		return resp, nil
+	})
+}
@@ -0,0 +1,61 @@
+package types
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestObjectCacheDiskBound(t *testing.T) {
+	dir := t.TempDir()
+	value := strings.Repeat("x", 100)
+	// Each entry is encoded as a quoted string of 102 bytes, so the directory
+	// holds two of them and memory one.
+	cache, err := NewObjectCache(150, dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Put("a", value)
+	cache.Put("b", value)
+	var got string
+	if !cache.Get("a", &got) || got != value {
+		t.Fatal("expected a to be read back from disk")
+	}
+	cache.Put("c", value)
+
+	stats := cache.Stats()
+	if stats.DiskEntries != 2 || stats.DiskBytes != 204 || stats.DiskEvictions != 1 {
+		t.Errorf("unexpected disk stats %+v", stats)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected two cache files, got %d", len(files))
+	}
+
+	// b was least recently used, so it is gone while a is kept.
+	reopened, err := NewObjectCache(150, dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Get("b", &got) {
+		t.Error("expected b to have been removed from disk")
+	}
+	for _, key := range []string{"a", "c"} {
+		if !reopened.Get(key, &got) {
+			t.Errorf("expected %s to survive a restart", key)
+		}
+	}
+
+	// A smaller bound prunes the files left by the earlier run.
+	pruned, err := NewObjectCache(150, dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := pruned.Stats(); stats.DiskEntries != 1 || stats.DiskBytes != 102 {
+		t.Errorf("expected the directory to be pruned to one file, got %+v", stats)
+	}
+}
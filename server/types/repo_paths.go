@@ -0,0 +1,127 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// workflowDir is the directory GitHub Actions reads workflows from.
+const workflowDir = ".github/workflows/"
+
+// Codes of the PathError reasons, returned to API clients in APIError.
+const (
+	ErrCodeInvalidPath         = "invalid_path"
+	ErrCodeInvalidWorkflowPath = "invalid_workflow_path"
+)
+
+// PathError reports a repository path from a request that was rejected
+// because it could reach outside of the repository or the directory it is
+// restricted to.
+type PathError struct {
+	Path   string
+	Reason string
+	Code   string
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("invalid path %q: %s", e.Path, e.Reason)
+}
+
+// CleanRepoPath validates a slash separated path relative to the root of a
+// repository and returns it cleaned. Empty and absolute paths, and paths with
+// ".." segments, are rejected.
+func CleanRepoPath(repoPath string) (string, error) {
+	invalid := func(reason string) (string, error) {
+		return "", &PathError{Path: repoPath, Reason: reason, Code: ErrCodeInvalidPath}
+	}
+
+	switch {
+	case strings.TrimSpace(repoPath) == "":
+		return invalid("path is empty")
+	case strings.ContainsAny(repoPath, "\x00\\"):
+		return invalid("path contains a NUL or backslash")
+	case path.IsAbs(repoPath) || filepath.IsAbs(repoPath) || filepath.VolumeName(repoPath) != "":
+		return invalid("path is absolute")
+	}
+
+	for _, segment := range strings.Split(repoPath, "/") {
+		if segment == ".." {
+			return invalid("path contains a .. segment")
+		}
+	}
+
+	cleaned := path.Clean(repoPath)
+	if cleaned == "." || cleaned == ".git" || strings.HasPrefix(cleaned, ".git/") {
+		return invalid("path does not name a file in the repository")
+	}
+
+	return cleaned, nil
+}
+
+// CleanRepoDir is CleanRepoPath for a directory, where the root of the
+// repository is allowed and returned as "".
+func CleanRepoDir(repoDir string) (string, error) {
+	if repoDir == "" || repoDir == "." || repoDir == "./" {
+		return "", nil
+	}
+
+	return CleanRepoPath(repoDir)
+}
+
+// CleanWorkflowPath is CleanRepoPath for a workflow file, which must be a YAML
+// file directly in .github/workflows/.
+func CleanWorkflowPath(workflowPath string) (string, error) {
+	cleaned, err := CleanRepoPath(workflowPath)
+	if err != nil {
+		return "", err
+	}
+
+	ext := path.Ext(cleaned)
+	if path.Dir(cleaned)+"/" != workflowDir || (ext != ".yml" && ext != ".yaml") {
+		return "", &PathError{Path: workflowPath, Reason: "workflows must be .yml or .yaml files in " + workflowDir, Code: ErrCodeInvalidWorkflowPath}
+	}
+
+	return cleaned, nil
+}
+
+// checkoutFilePath returns the path on disk of repoPath in the checkout at
+// root. Symlinks in the checkout are resolved, so a link pointing out of it
+// is rejected as well.
+func checkoutFilePath(root, repoPath string) (string, error) {
+	cleaned, err := CleanRepoPath(repoPath)
+	if err != nil {
+		return "", err
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	filePath := filepath.Join(resolvedRoot, filepath.FromSlash(cleaned))
+
+	// Resolve the deepest existing parent, the file itself may not exist yet.
+	existing := filePath
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			existing = filepath.Join(resolved, strings.TrimPrefix(filePath, existing))
+			break
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	rel, err := filepath.Rel(resolvedRoot, existing)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &PathError{Path: repoPath, Reason: "path leaves the repository", Code: ErrCodeInvalidPath}
+	}
+
+	return filePath, nil
+}
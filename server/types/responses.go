@@ -1,35 +1,55 @@
 package types
 
 import (
+	"time"
+
 	"github.com/google/go-github/v38/github"
 )
 
 type APIError struct {
 	Error string `json:"error"`
+	// Code identifies the error for clients, when it has one.
+	Code string `json:"code,omitempty"`
 }
 
 type RepositoriesResponse struct {
-	Data []*Repository `json:"data"`
+	Data       []*Repository `json:"data"`
+	Pagination *Pagination   `json:"pagination"`
 }
 
 type BranchesResponse struct {
-	Data []*Branch `json:"data"`
+	Data       []*Branch   `json:"data"`
+	Pagination *Pagination `json:"pagination"`
+}
+
+// Pagination describes the page returned by a list endpoint. TotalCount is
+// the number of entries matching the filter across all pages, left out when
+// the provider does not report it. HasMore is set when pages follow.
+type Pagination struct {
+	Page       int  `json:"page"`
+	PerPage    int  `json:"perPage"`
+	TotalCount *int `json:"totalCount,omitempty"`
+	HasMore    bool `json:"hasMore"`
 }
 
 type ManifestOptionResponse struct {
-	Data []*ManifestOption `json:"data"`
+	Data    []*ManifestOption `json:"data"`
+	Partial bool              `json:"partial"`
 }
 
 type WorkflowResponse struct {
-	Data []*Workflow `json:"data"`
+	Data    []*Workflow `json:"data"`
+	Partial bool        `json:"partial"`
 }
 
 type ServiceResponse struct {
-	Data []*Service `json:"data"`
+	Data    []*Service `json:"data"`
+	Partial bool       `json:"partial"`
 }
 
 type TreeResponse struct {
-	Data []*TreeEntry `json:"data"`
+	Data    []*TreeEntry `json:"data"`
+	Partial bool         `json:"partial"`
 }
 
 type ActionResponse struct {
@@ -37,7 +57,8 @@ type ActionResponse struct {
 }
 
 type ListWorkflowResponse struct {
-	Data []*WorkflowDefinition `json:"data"`
+	Data    []*WorkflowDefinition `json:"data"`
+	Partial bool                  `json:"partial"`
 }
 
 type Repository struct {
@@ -52,9 +73,20 @@ type Branch struct {
 	Name string `json:"name"`
 }
 
+// Types of a ManifestOption, the way its manifests are generated.
+const (
+	ManifestOptionHelm      = "helm"
+	ManifestOptionKustomize = "kustomize"
+	ManifestOptionManifests = "manifests"
+)
+
 type ManifestOption struct {
 	SHA  string `json:"sha"`
 	Path string `json:"path"`
+	Type string `json:"type"`
+	// ValuesFiles are the Helm values files next to a Chart.yaml, which can be
+	// passed to the services endpoint as valuesFile.
+	ValuesFiles []string `json:"valuesFiles,omitempty"`
 }
 
 type Workflow struct {
@@ -64,6 +96,8 @@ type Workflow struct {
 
 type Service struct {
 	Name string `json:"name"`
+	// Source is the file the service is defined in.
+	Source string `json:"source"`
 }
 
 type TreeEntry struct {
@@ -103,6 +137,28 @@ type WorkflowFile struct {
 	Contents string `json:"contents"`
 }
 
+type RateLimitResponse struct {
+	Data *RateLimit `json:"data"`
+}
+
+type RateLimit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+type CacheStatsResponse struct {
+	Data *CacheStats `json:"data"`
+}
+
+type UserResponse struct {
+	Data *User `json:"data"`
+}
+
+type User struct {
+	Login string `json:"login"`
+}
+
 type WorkflowFileResponse struct {
 	Data *WorkflowFile  `json:"data"`
 }
@@ -118,6 +174,10 @@ func GitHubToResponseRepository(repoOwner string, repos []*github.Repository) []
 			Owner: repoOwner,
 		}
 
+		if repo.Owner != nil && repo.Owner.Login != nil {
+			respRepo.Owner = *repo.Owner.Login
+		}
+
 		if repo.ID != nil {
 			respRepo.ID = *repo.ID
 		}
@@ -0,0 +1,155 @@
+package types
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Session is a logged in user and the OAuth token used on their behalf.
+type Session struct {
+	ID                  string
+	UserLogin           string
+	Token               *oauth2.Token
+	LastActionTimestamp time.Time
+}
+
+// SessionStore keeps sessions in memory. Sessions that have not been used for
+// longer than the idle timeout are dropped.
+type SessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*Session
+	idleTimeout time.Duration
+}
+
+func NewSessionStore(idleTimeout time.Duration) *SessionStore {
+	store := &SessionStore{
+		sessions:    make(map[string]*Session),
+		idleTimeout: idleTimeout,
+	}
+
+	go func() {
+		for range time.Tick(time.Minute * 5) {
+			store.cleanupSessions()
+		}
+	}()
+
+	return store
+}
+
+func (ss *SessionStore) Create(userLogin string, token *oauth2.Token) (*Session, error) {
+	id, err := generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("[CreateSession] error generating session id: %s", err.Error())
+	}
+
+	session := &Session{
+		ID:                  id,
+		UserLogin:           userLogin,
+		Token:               token,
+		LastActionTimestamp: time.Now(),
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sessions[id] = session
+	return session, nil
+}
+
+// Get returns a copy of the session and marks it as used.
+func (ss *SessionStore) Get(id string) (Session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, ok := ss.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+
+	if time.Now().After(session.LastActionTimestamp.Add(ss.idleTimeout)) {
+		delete(ss.sessions, id)
+		return Session{}, false
+	}
+
+	session.LastActionTimestamp = time.Now()
+	return *session, true
+}
+
+// TokenSource wraps source, the token source of session, so the tokens it
+// refreshes are saved back to the session. Otherwise the next request would
+// refresh again from the token the session was created with.
+func (ss *SessionStore) TokenSource(session Session, source oauth2.TokenSource) oauth2.TokenSource {
+	return &sessionTokenSource{store: ss, id: session.ID, source: source, last: session.Token}
+}
+
+// updateToken replaces the token of a session that has not ended.
+func (ss *SessionStore) updateToken(id string, token *oauth2.Token) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if session, ok := ss.sessions[id]; ok {
+		session.Token = token
+	}
+}
+
+type sessionTokenSource struct {
+	store  *SessionStore
+	id     string
+	source oauth2.TokenSource
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (sts *sessionTokenSource) Token() (*oauth2.Token, error) {
+	token, err := sts.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	sts.mu.Lock()
+	defer sts.mu.Unlock()
+	if sts.last == nil || token.AccessToken != sts.last.AccessToken {
+		sts.store.updateToken(sts.id, token)
+		sts.last = token
+	}
+
+	return token, nil
+}
+
+func (ss *SessionStore) Delete(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.sessions, id)
+}
+
+func (ss *SessionStore) cleanupSessions() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	expired := 0
+	for id, session := range ss.sessions {
+		if time.Now().After(session.LastActionTimestamp.Add(ss.idleTimeout)) {
+			delete(ss.sessions, id)
+			expired++
+		}
+	}
+
+	if expired > 0 {
+		log.Println("[cleanupSessions] Expired sessions removed: ", expired)
+	}
+}
+
+func generateSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,52 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshingSource hands out token until it is replaced, like a refresh.
+type refreshingSource struct {
+	token *oauth2.Token
+}
+
+func (rs *refreshingSource) Token() (*oauth2.Token, error) {
+	return rs.token, nil
+}
+
+func TestSessionTokenSourceSavesRefreshedToken(t *testing.T) {
+	store := &SessionStore{sessions: make(map[string]*Session), idleTimeout: time.Hour}
+	created, err := store.Create("octocat", &oauth2.Token{AccessToken: "first", RefreshToken: "refresh-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, _ := store.Get(created.ID)
+	source := &refreshingSource{token: session.Token}
+	tokenSource := store.TokenSource(session, source)
+	if _, err := tokenSource.Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	source.token = &oauth2.Token{AccessToken: "second", RefreshToken: "refresh-2"}
+	if _, err := tokenSource.Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	session, _ = store.Get(created.ID)
+	if session.Token.AccessToken != "second" || session.Token.RefreshToken != "refresh-2" {
+		t.Errorf("expected the refreshed token to be saved, got %+v", session.Token)
+	}
+
+	// A session ended meanwhile is not brought back.
+	store.Delete(created.ID)
+	source.token = &oauth2.Token{AccessToken: "third"}
+	if _, err := tokenSource.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get(created.ID); ok {
+		t.Error("expected the deleted session to stay deleted")
+	}
+}
@@ -0,0 +1,134 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-github/v38/github"
+)
+
+// SourceControlProvider is the set of repository operations the route handlers
+// rely on. GitHubClient, GitHubAppProvider and GitLabClient talk to their
+// respective APIs,
+// LocalGitProvider works on repositories on disk, and FakeProvider keeps
+// everything in memory so the handlers can be exercised without network access.
+type SourceControlProvider interface {
+	ListRepositories(ctx context.Context, repoOwner string) ([]*github.Repository, error)
+	ListRepositoryBranches(ctx context.Context, repoOwner, repoName string) ([]*github.Branch, error)
+	GetRepositoryBranch(ctx context.Context, repoOwner, repoName, branchName string) (*github.Branch, error)
+	GetBranchTree(ctx context.Context, repoOwner, repoName, branchSHA string) (*github.Tree, error)
+	GetBlob(ctx context.Context, repoOwner, repoName, blobSHA string) (*github.Blob, error)
+	GetReference(ctx context.Context, sourceOwner, sourceRepo, commitBranch, baseBranch string) (*github.Reference, error)
+	// GenerateCommitTree builds the tree of the commit of ref with changes
+	// applied. The changes must have been validated with CleanFileChanges.
+	GenerateCommitTree(ctx context.Context, ref *github.Reference, sourceOwner, sourceRepo string, changes []FileChange) (*github.Tree, error)
+	CreateCommit(ctx context.Context, ref *github.Reference, tree *github.Tree, sourceOwner, sourceRepo string) (*github.Commit, error)
+	CreatePullRequest(ctx context.Context, prSubject, prRepoOwner, prRepo, prBranch, prDescription, sourceOwner, sourceRepo, commitBranch string) (*github.PullRequest, error)
+	// CloneURL returns the git remote used by LocalRepoService to clone the
+	// repository, or an empty string if the provider cannot be cloned.
+	// Credentials may be embedded in the URL; LocalRepoService hands them to
+	// the git transport and never writes the URL to .git/config.
+	CloneURL(repoOwner, repoName string) string
+}
+
+// PageLister is implemented by providers that can fetch a single page of a
+// list upstream, so a paged request without a name filter does not fetch
+// every page. Along with the page they describe where it sits in the list.
+type PageLister interface {
+	ListRepositoriesPage(ctx context.Context, repoOwner string, page, perPage int) ([]*github.Repository, ListPage, error)
+	ListRepositoryBranchesPage(ctx context.Context, repoOwner, repoName string, page, perPage int) ([]*github.Branch, ListPage, error)
+}
+
+// ListPage describes a page of a list fetched upstream.
+type ListPage struct {
+	// TotalCount is the number of entries across all pages, only meaningful
+	// when TotalKnown is set.
+	TotalCount int
+	TotalKnown bool
+	// HasMore is set when pages follow this one.
+	HasMore bool
+}
+
+// knownListPage describes the page page of perPage entries out of total.
+func knownListPage(page, perPage, total int) ListPage {
+	return ListPage{TotalCount: total, TotalKnown: true, HasMore: page*perPage < total}
+}
+
+// Git modes of the files written by a FileChange.
+const (
+	FileModeRegular    = "100644"
+	FileModeExecutable = "100755"
+)
+
+// FileChange is a change to a single file of a commit. The file at Path, a
+// slash separated path relative to the root of the repository, is replaced
+// with Content, or removed if Delete is set.
+type FileChange struct {
+	Path    string
+	Content []byte
+	// Mode is FileModeRegular or FileModeExecutable, empty means regular.
+	Mode   string
+	Delete bool
+}
+
+// CleanFileChanges validates the paths and modes of changes and returns them
+// with the paths cleaned and the default mode filled in.
+func CleanFileChanges(changes []FileChange) ([]FileChange, error) {
+	if len(changes) == 0 {
+		return nil, errors.New("no file changes to commit")
+	}
+
+	cleaned := make([]FileChange, 0, len(changes))
+	seen := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		filePath, err := CleanRepoPath(change.Path)
+		if err != nil {
+			return nil, err
+		}
+		if seen[filePath] {
+			return nil, fmt.Errorf("file %s is changed more than once", filePath)
+		}
+		seen[filePath] = true
+
+		switch change.Mode {
+		case "":
+			change.Mode = FileModeRegular
+		case FileModeRegular, FileModeExecutable:
+		default:
+			return nil, fmt.Errorf("file %s has unsupported mode %s", filePath, change.Mode)
+		}
+
+		change.Path = filePath
+		cleaned = append(cleaned, change)
+	}
+
+	return cleaned, nil
+}
+
+// treeEntry returns change as an entry of a GitHub tree. Deletions have
+// neither content nor a SHA, which is how the GitHub API expects them.
+func (change FileChange) treeEntry() *github.TreeEntry {
+	entry := &github.TreeEntry{
+		Path: github.String(change.Path),
+		Type: github.String("blob"),
+		Mode: github.String(change.Mode),
+	}
+	if !change.Delete {
+		entry.Content = github.String(string(change.Content))
+	}
+
+	return entry
+}
+
+var (
+	_ SourceControlProvider = &GitHubClient{}
+	_ SourceControlProvider = &GitHubAppProvider{}
+	_ SourceControlProvider = &GitLabClient{}
+	_ SourceControlProvider = &LocalGitProvider{}
+	_ SourceControlProvider = &FakeProvider{}
+
+	_ PageLister = &GitHubClient{}
+	_ PageLister = &GitHubAppProvider{}
+	_ PageLister = &GitLabClient{}
+)
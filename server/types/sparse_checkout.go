@@ -0,0 +1,205 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v2"
+)
+
+// chartMetadata is the part of a Chart.yaml, or a Helm 2 requirements.yaml,
+// listing the dependencies of a chart.
+type chartMetadata struct {
+	Dependencies []struct {
+		Repository string `yaml:"repository"`
+	} `yaml:"dependencies"`
+}
+
+// commitTree returns the tree of the commit commitSHA.
+func commitTree(repo *git.Repository, commitSHA string) (*object.Tree, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitSHA))
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.Tree()
+}
+
+// chartDirs returns chartDir and the directories of the file:// dependencies
+// it declares, recursively. Dependencies outside the repository are ignored.
+func chartDirs(tree *object.Tree, chartDir string) []string {
+	visited := make(map[string]bool)
+
+	var visit func(dir string)
+	visit = func(dir string) {
+		if visited[dir] {
+			return
+		}
+		visited[dir] = true
+
+		for _, metadataFile := range []string{"Chart.yaml", "requirements.yaml"} {
+			file, err := tree.File(path.Join(dir, metadataFile))
+			if err != nil {
+				continue
+			}
+
+			contents, err := file.Contents()
+			if err != nil {
+				continue
+			}
+
+			metadata := chartMetadata{}
+			if err := yaml.Unmarshal([]byte(contents), &metadata); err != nil {
+				continue
+			}
+
+			for _, dependency := range metadata.Dependencies {
+				if !strings.HasPrefix(dependency.Repository, "file://") {
+					continue
+				}
+
+				dependencyDir, ok := repoRelativeDir(path.Join(dir, strings.TrimPrefix(dependency.Repository, "file://")))
+				if ok {
+					visit(dependencyDir)
+				}
+			}
+		}
+	}
+	visit(chartDir)
+
+	dirs := make([]string, 0, len(visited))
+	for dir := range visited {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// repoRelativeDir cleans dir, a slash separated path relative to the root of
+// the repository, reporting false if it points outside of it. The root is
+// returned as "".
+func repoRelativeDir(dir string) (string, bool) {
+	dir = path.Clean(dir)
+	if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+		return "", false
+	}
+
+	if dir == "." {
+		return "", true
+	}
+
+	return dir, true
+}
+
+// dirCovered reports whether dir or one of its parents is in dirs.
+func dirCovered(dirs map[string]bool, dir string) bool {
+	for {
+		if dirs[dir] || dirs[""] {
+			return true
+		}
+
+		if dir == "" || !strings.Contains(dir, "/") {
+			return false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// checkoutDir writes the files under dir of tree into the worktree at
+// repoDir.
+func checkoutDir(tree *object.Tree, repoDir, dir string) error {
+	subtree := tree
+	if dir != "" {
+		var err error
+		subtree, err = tree.Tree(dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	return subtree.Files().ForEach(func(file *object.File) error {
+		return writeTreeFile(file, filepath.Join(repoDir, filepath.FromSlash(dir), filepath.FromSlash(file.Name)))
+	})
+}
+
+// writeTreeFile writes file to filePath. Symlinks are skipped like in
+// copyTreeFile, a chart read through one could read anywhere on the host.
+func writeTreeFile(file *object.File, filePath string) error {
+	if file.Mode == filemode.Symlink {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	if file.Mode == filemode.Executable {
+		perm = 0755
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// clearWorktree removes everything in repoDir except the git directory.
+func clearWorktree(repoDir string) error {
+	entries, err := ioutil.ReadDir(repoDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == git.GitDirName {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(repoDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diskUsage returns the size of the files under dir.
+func diskUsage(dir string) (int64, error) {
+	var usage int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			usage += info.Size()
+		}
+		return nil
+	})
+
+	return usage, err
+}
@@ -0,0 +1,58 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCheckoutDirSkipsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app", "values.yaml"), []byte("port: 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "app", "secrets.yaml")); err != nil {
+		t.Skip("symlinks are not supported: ", err)
+	}
+	if _, err := worktree.Add("app"); err != nil {
+		t.Fatal(err)
+	}
+	commit, err := worktree.Commit("fixture", &git.CommitOptions{
+		Author: &object.Signature{Name: commitName, Email: commitEmail, When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := commitTree(repo, commit.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkout := t.TempDir()
+	if err := checkoutDir(tree, checkout, "app"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(checkout, "app", "values.yaml")); err != nil {
+		t.Errorf("expected values.yaml to be checked out: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(checkout, "app", "secrets.yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected the symlink to be skipped, got %v", err)
+	}
+}